@@ -25,6 +25,15 @@ import (
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:resource:scope=Namespaced,categories={krateo}
+// +kubebuilder:printcolumn:name="HEALTH",type="string",JSONPath=".status.health"
+// +kubebuilder:printcolumn:name="SCORE",type="integer",JSONPath=".status.healthScore"
+// +kubebuilder:printcolumn:name="TREE HEALTHY",type="string",JSONPath=".status.conditions[?(@.type=='TreeHealthy')].status"
+// +kubebuilder:printcolumn:name="RESOURCES",type="integer",JSONPath=".status.resourceCount"
+// +kubebuilder:printcolumn:name="LAST SYNC",type="date",JSONPath=".status.lastSyncTime"
+// +kubebuilder:printcolumn:name="COMPOSITION UID",type="string",JSONPath=".status.compositionUID",priority=1
+// +kubebuilder:printcolumn:name="EXCLUDED",type="integer",JSONPath=".status.excludedCount",priority=1
+// +kubebuilder:printcolumn:name="SYNC ERROR",type="string",JSONPath=".status.lastSyncError",priority=1
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
 
 type CompositionReference struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -35,12 +44,58 @@ type CompositionReference struct {
 }
 
 type CompositionReferenceSpec struct {
-	Filters   Filters   `json:"filters"`
-	Reference Reference `json:"reference"`
+	Filters Filters `json:"filters"`
+	// Reference points to a single composition. Either reference or selector must be set.
+	// +optional
+	Reference *Reference `json:"reference,omitempty"`
+	// Selector matches every composition of a given resource type by labels.
+	// +optional
+	Selector *Selector `json:"selector,omitempty"`
+	// Children enables the discovery of the resources owned, through ownerReferences, by the managed resources.
+	// +optional
+	Children *Children `json:"children,omitempty"`
+	// Health configures the aggregated health of the tree.
+	// +optional
+	Health *HealthPolicy `json:"health,omitempty"`
+	// WatchedPaths lists the dot separated fields of the compositions, e.g. status.managed, whose changes
+	// rebuild the tree. Defaults to spec, status.managed, status.conditions, metadata.labels,
+	// metadata.annotations and metadata.deletionTimestamp.
+	// +optional
+	WatchedPaths []string `json:"watchedPaths,omitempty"`
 }
 
 type CompositionReferenceStatus struct {
 	prv1.ConditionedStatus `json:",inline"`
+	// Matched lists the compositions currently tracked by this CompositionReference.
+	// +optional
+	Matched []MatchedComposition `json:"matched,omitempty"`
+	// Health is the worst health among the resources of the tracked compositions.
+	// +optional
+	Health string `json:"health,omitempty"`
+	// HealthScore is the weighted percentage of healthy resources.
+	// +optional
+	HealthScore *int `json:"healthScore,omitempty"`
+	// ObservedGeneration is the generation of the spec the last sync was based on.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// CompositionUID is the UID of the tracked composition, it is empty when a selector matches more than one.
+	// +optional
+	CompositionUID string `json:"compositionUID,omitempty"`
+	// LastSyncTime is when the tree was last pushed to the resource-tree-handler.
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+	// LastSyncError is the error of the last failed sync, it is cleared by the next successful one.
+	// +optional
+	LastSyncError string `json:"lastSyncError,omitempty"`
+	// ResourceCount is the number of resources in the tree.
+	// +optional
+	ResourceCount int `json:"resourceCount,omitempty"`
+	// ExcludedCount is the number of resources left out of the tree by the filters.
+	// +optional
+	ExcludedCount int `json:"excludedCount,omitempty"`
+	// UnreachableResources lists the managed resources that could not be fetched.
+	// +optional
+	UnreachableResources []Reference `json:"unreachableResources,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -63,21 +118,119 @@ func (mg *CompositionReference) SetConditions(c ...prv1.Condition) {
 	mg.Status.SetConditions(c...)
 }
 
+// Filters select the resources that are part of the resource tree. When include is
+// not empty only the managed resources matching at least one include rule are kept.
+// Exclude rules are evaluated afterwards and always take precedence over include rules.
 type Filters struct {
-	Exclude []Exclude `json:"exclude"`
+	// +optional
+	Exclude []FilterRule `json:"exclude,omitempty"`
+	// +optional
+	Include []FilterRule `json:"include,omitempty"`
 }
 
-type Exclude struct {
-	ApiVersion string `json:"apiVersion"`
+// MatchType selects how the string fields of a filter rule are matched.
+// +kubebuilder:validation:Enum=Exact;Glob;Regex;Substring
+type MatchType string
+
+const (
+	// MatchTypeExact matches when the field is equal to the pattern.
+	MatchTypeExact MatchType = "Exact"
+	// MatchTypeGlob matches the whole field, "*" matches any sequence of characters and "?" a single character.
+	MatchTypeGlob MatchType = "Glob"
+	// MatchTypeRegex matches when the regex, anchored at both ends, matches the whole field.
+	MatchTypeRegex MatchType = "Regex"
+	// MatchTypeSubstring matches when the field contains the pattern.
+	MatchTypeSubstring MatchType = "Substring"
+)
+
+// FilterRule matches managed resources, it is used by both include and exclude filters.
+// All the fields that are set must match.
+type FilterRule struct {
+	// MatchType applies to apiVersion, resource, name, namespace and annotation values. When omitted
+	// a field matches if it is equal to the pattern or if the pattern, as an unanchored regex, matches it.
+	// +optional
+	MatchType MatchType `json:"matchType,omitempty"`
+	// +optional
+	ApiVersion string `json:"apiVersion,omitempty"`
 	// +optional
 	Resource string `json:"resource"`
 	// +optional
 	Name string `json:"name"`
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// LabelSelector is matched against the labels of the fetched resource.
+	// +optional
+	LabelSelector *metav1.LabelSelector `json:"labelSelector,omitempty"`
+	// Annotations must all be present on the fetched resource, an empty value only checks for the key.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Expression is a CEL expression evaluated against the fetched resource, available as "object".
+	// +optional
+	Expression string `json:"expression,omitempty"`
+}
+
+// Exclude is the former name of FilterRule.
+//
+// Deprecated: use FilterRule.
+type Exclude = FilterRule
+
+type Selector struct {
+	ApiVersion string `json:"apiVersion"`
+	Resource   string `json:"resource"`
+	// Namespace restricts the search to a single namespace, all namespaces are searched when empty.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// +optional
+	LabelSelector *metav1.LabelSelector `json:"labelSelector,omitempty"`
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+}
+
+type Children struct {
+	// Depth is the number of ownerReference levels followed down from each managed resource.
+	// +kubebuilder:validation:Minimum=1
+	Depth int `json:"depth"`
+	// Resources lists the resource types searched for children, e.g. apps/v1 replicasets and v1 pods.
+	Resources []ChildResource `json:"resources"`
+}
+
+type ChildResource struct {
+	ApiVersion string `json:"apiVersion"`
+	Resource   string `json:"resource"`
+}
+
+type HealthPolicy struct {
+	// Weights sets the criticality of kinds in the rollup, kinds that are not listed have weight 1.
+	// +optional
+	Weights []KindWeight `json:"weights,omitempty"`
+}
+
+type KindWeight struct {
+	// Group of the kind, empty for the core group.
+	// +optional
+	Group string `json:"group,omitempty"`
+	Kind  string `json:"kind"`
+	// Weight of the kind in the health score, 0 leaves the kind out of the rollup.
+	// +kubebuilder:validation:Minimum=0
+	Weight int `json:"weight"`
+}
+
+type MatchedComposition struct {
+	Reference `json:",inline"`
+	UID       string `json:"uid"`
+	// TreeHash is the hash of the last tree successfully pushed to the resource-tree-handler.
+	// +optional
+	TreeHash string `json:"treeHash,omitempty"`
+	// LastSyncTime is when the tree of this composition was last pushed.
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
 }
 
 type Reference struct {
 	ApiVersion string `json:"apiVersion"`
 	Resource   string `json:"resource"`
 	Name       string `json:"name"`
-	Namespace  string `json:"namespace"`
+	// Namespace defaults to the namespace of the CompositionReference when the webhook is enabled.
+	// +optional
+	Namespace string `json:"namespace"`
 }
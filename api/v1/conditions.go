@@ -0,0 +1,79 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	prv1 "github.com/krateoplatformops/provider-runtime/apis/common/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TypeFiltersValid reports whether all the filters of a CompositionReference compiled.
+const TypeFiltersValid prv1.ConditionType = "FiltersValid"
+
+// TypeTreeHealthy reports whether all the resources of the tracked compositions are healthy.
+const TypeTreeHealthy prv1.ConditionType = "TreeHealthy"
+
+// Reasons a CompositionReference filters are or are not valid.
+const (
+	ReasonFiltersCompiled prv1.ConditionReason = "FiltersCompiled"
+	ReasonInvalidFilters  prv1.ConditionReason = "InvalidFilters"
+)
+
+// FiltersValid returns a condition that indicates all the filters compiled.
+func FiltersValid() prv1.Condition {
+	return prv1.Condition{
+		Type:               TypeFiltersValid,
+		Status:             metav1.ConditionTrue,
+		LastTransitionTime: metav1.Now(),
+		Reason:             ReasonFiltersCompiled,
+	}
+}
+
+// FiltersInvalid returns a condition that indicates some filters could not be
+// compiled and are being ignored.
+func FiltersInvalid(err error) prv1.Condition {
+	return prv1.Condition{
+		Type:               TypeFiltersValid,
+		Status:             metav1.ConditionFalse,
+		LastTransitionTime: metav1.Now(),
+		Reason:             ReasonInvalidFilters,
+		Message:            err.Error(),
+	}
+}
+
+// TreeHealthy returns a condition that indicates all the resources of the tree
+// are healthy.
+func TreeHealthy() prv1.Condition {
+	return prv1.Condition{
+		Type:               TypeTreeHealthy,
+		Status:             metav1.ConditionTrue,
+		LastTransitionTime: metav1.Now(),
+		Reason:             "Healthy",
+	}
+}
+
+// TreeNotHealthy returns a condition that indicates some resources of the tree
+// are not healthy. The reason is the aggregated health of the tree.
+func TreeNotHealthy(health string, message string) prv1.Condition {
+	return prv1.Condition{
+		Type:               TypeTreeHealthy,
+		Status:             metav1.ConditionFalse,
+		LastTransitionTime: metav1.Now(),
+		Reason:             prv1.ConditionReason(health),
+		Message:            message,
+	}
+}
@@ -21,9 +21,45 @@ limitations under the License.
 package v1
 
 import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChildResource) DeepCopyInto(out *ChildResource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChildResource.
+func (in *ChildResource) DeepCopy() *ChildResource {
+	if in == nil {
+		return nil
+	}
+	out := new(ChildResource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Children) DeepCopyInto(out *Children) {
+	*out = *in
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = make([]ChildResource, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Children.
+func (in *Children) DeepCopy() *Children {
+	if in == nil {
+		return nil
+	}
+	out := new(Children)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CompositionReference) DeepCopyInto(out *CompositionReference) {
 	*out = *in
@@ -87,7 +123,31 @@ func (in *CompositionReferenceList) DeepCopyObject() runtime.Object {
 func (in *CompositionReferenceSpec) DeepCopyInto(out *CompositionReferenceSpec) {
 	*out = *in
 	in.Filters.DeepCopyInto(&out.Filters)
-	out.Reference = in.Reference
+	if in.Reference != nil {
+		in, out := &in.Reference, &out.Reference
+		*out = new(Reference)
+		**out = **in
+	}
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(Selector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Children != nil {
+		in, out := &in.Children, &out.Children
+		*out = new(Children)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Health != nil {
+		in, out := &in.Health, &out.Health
+		*out = new(HealthPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.WatchedPaths != nil {
+		in, out := &in.WatchedPaths, &out.WatchedPaths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CompositionReferenceSpec.
@@ -104,6 +164,27 @@ func (in *CompositionReferenceSpec) DeepCopy() *CompositionReferenceSpec {
 func (in *CompositionReferenceStatus) DeepCopyInto(out *CompositionReferenceStatus) {
 	*out = *in
 	in.ConditionedStatus.DeepCopyInto(&out.ConditionedStatus)
+	if in.Matched != nil {
+		in, out := &in.Matched, &out.Matched
+		*out = make([]MatchedComposition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.HealthScore != nil {
+		in, out := &in.HealthScore, &out.HealthScore
+		*out = new(int)
+		**out = **in
+	}
+	if in.LastSyncTime != nil {
+		in, out := &in.LastSyncTime, &out.LastSyncTime
+		*out = (*in).DeepCopy()
+	}
+	if in.UnreachableResources != nil {
+		in, out := &in.UnreachableResources, &out.UnreachableResources
+		*out = make([]Reference, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CompositionReferenceStatus.
@@ -117,16 +198,28 @@ func (in *CompositionReferenceStatus) DeepCopy() *CompositionReferenceStatus {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *Exclude) DeepCopyInto(out *Exclude) {
+func (in *FilterRule) DeepCopyInto(out *FilterRule) {
 	*out = *in
+	if in.LabelSelector != nil {
+		in, out := &in.LabelSelector, &out.LabelSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Exclude.
-func (in *Exclude) DeepCopy() *Exclude {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FilterRule.
+func (in *FilterRule) DeepCopy() *FilterRule {
 	if in == nil {
 		return nil
 	}
-	out := new(Exclude)
+	out := new(FilterRule)
 	in.DeepCopyInto(out)
 	return out
 }
@@ -136,8 +229,17 @@ func (in *Filters) DeepCopyInto(out *Filters) {
 	*out = *in
 	if in.Exclude != nil {
 		in, out := &in.Exclude, &out.Exclude
-		*out = make([]Exclude, len(*in))
-		copy(*out, *in)
+		*out = make([]FilterRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Include != nil {
+		in, out := &in.Include, &out.Include
+		*out = make([]FilterRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 }
 
@@ -151,6 +253,61 @@ func (in *Filters) DeepCopy() *Filters {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HealthPolicy) DeepCopyInto(out *HealthPolicy) {
+	*out = *in
+	if in.Weights != nil {
+		in, out := &in.Weights, &out.Weights
+		*out = make([]KindWeight, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HealthPolicy.
+func (in *HealthPolicy) DeepCopy() *HealthPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(HealthPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KindWeight) DeepCopyInto(out *KindWeight) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KindWeight.
+func (in *KindWeight) DeepCopy() *KindWeight {
+	if in == nil {
+		return nil
+	}
+	out := new(KindWeight)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MatchedComposition) DeepCopyInto(out *MatchedComposition) {
+	*out = *in
+	out.Reference = in.Reference
+	if in.LastSyncTime != nil {
+		in, out := &in.LastSyncTime, &out.LastSyncTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MatchedComposition.
+func (in *MatchedComposition) DeepCopy() *MatchedComposition {
+	if in == nil {
+		return nil
+	}
+	out := new(MatchedComposition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Reference) DeepCopyInto(out *Reference) {
 	*out = *in
@@ -165,3 +322,28 @@ func (in *Reference) DeepCopy() *Reference {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Selector) DeepCopyInto(out *Selector) {
+	*out = *in
+	if in.LabelSelector != nil {
+		in, out := &in.LabelSelector, &out.LabelSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Selector.
+func (in *Selector) DeepCopy() *Selector {
+	if in == nil {
+		return nil
+	}
+	out := new(Selector)
+	in.DeepCopyInto(out)
+	return out
+}
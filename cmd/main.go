@@ -21,6 +21,7 @@ import (
 	"flag"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
@@ -41,6 +42,9 @@ import (
 	watcher "github.com/krateoplatformops/composition-watcher/api/v1"
 
 	compositionReferenceController "github.com/krateoplatformops/composition-watcher/internal/controller"
+	"github.com/krateoplatformops/composition-watcher/internal/helpers/debug"
+	"github.com/krateoplatformops/composition-watcher/internal/helpers/enrollment"
+	webhookwatcherv1 "github.com/krateoplatformops/composition-watcher/internal/webhook/v1"
 	"github.com/krateoplatformops/provider-runtime/pkg/controller"
 	"github.com/krateoplatformops/provider-runtime/pkg/logging"
 	"github.com/krateoplatformops/provider-runtime/pkg/ratelimiter"
@@ -166,11 +170,35 @@ func main() {
 		GlobalRateLimiter:       ratelimiter.NewGlobal(maxReconcileRate),
 	}
 
-	if err := compositionReferenceController.Setup(mgr, o); err != nil {
+	debugOptions := debug.Options{
+		BindAddress:   metricsAddr,
+		SecureServing: secureMetrics,
+	}
+	if err := compositionReferenceController.Setup(mgr, o, debugOptions); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "CompositionReference")
 		os.Exit(1)
 	}
 
+	autoEnroll, _ := strconv.ParseBool(os.Getenv("AUTO_ENROLL"))
+	if autoEnroll {
+		enrollmentOptions := enrollment.Options{
+			AllowedNamespaces:  splitList(os.Getenv("AUTO_ENROLL_NAMESPACES")),
+			ExcludedNamespaces: splitList(os.Getenv("AUTO_ENROLL_EXCLUDED_NAMESPACES")),
+		}
+		if err := enrollment.Setup(mgr, o, enrollmentOptions); err != nil {
+			setupLog.Error(err, "unable to set up automatic enrollment")
+			os.Exit(1)
+		}
+	}
+
+	enableWebhooks, _ := strconv.ParseBool(os.Getenv("ENABLE_WEBHOOKS"))
+	if enableWebhooks {
+		if err := webhookwatcherv1.SetupCompositionReferenceWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "CompositionReference")
+			os.Exit(1)
+		}
+	}
+
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -188,3 +216,14 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// splitList parses a comma separated environment variable, ignoring empty items
+func splitList(value string) []string {
+	res := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
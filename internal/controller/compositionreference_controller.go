@@ -20,20 +20,33 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/krateoplatformops/provider-runtime/pkg/controller"
 	"github.com/krateoplatformops/provider-runtime/pkg/logging"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	ctrlevent "sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	watcher "github.com/krateoplatformops/composition-watcher/api/v1"
+	"github.com/krateoplatformops/composition-watcher/internal/helpers/debug"
+	"github.com/krateoplatformops/composition-watcher/internal/helpers/filters"
+	"github.com/krateoplatformops/composition-watcher/internal/helpers/health"
 	httpHelper "github.com/krateoplatformops/composition-watcher/internal/helpers/http"
 	informerHelper "github.com/krateoplatformops/composition-watcher/internal/helpers/informer"
 	clientHelper "github.com/krateoplatformops/composition-watcher/internal/helpers/kube/client"
@@ -41,6 +54,7 @@ import (
 
 	prv1 "github.com/krateoplatformops/provider-runtime/apis/common/v1"
 	"github.com/krateoplatformops/provider-runtime/pkg/event"
+	"github.com/krateoplatformops/provider-runtime/pkg/meta"
 	"github.com/krateoplatformops/provider-runtime/pkg/ratelimiter"
 	"github.com/krateoplatformops/provider-runtime/pkg/reconciler"
 	"github.com/krateoplatformops/provider-runtime/pkg/resource"
@@ -48,13 +62,14 @@ import (
 
 const (
 	errNotCompositionReference = "managed resource is not a composition reference custom resource"
+	errMissingReference        = "composition reference must specify either a reference or a selector"
 )
 
 //+kubebuilder:rbac:groups=*,resources=*,verbs=get;list;watch
 //+kubebuilder:rbac:groups=resourcetrees.krateo.io,resources=compositionreferences,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=resourcetrees.krateo.io,resources=compositionreferences/status,verbs=get;update;patch
 
-func Setup(mgr ctrl.Manager, o controller.Options) error {
+func Setup(mgr ctrl.Manager, o controller.Options, debugOpts debug.Options) error {
 	name := reconciler.ControllerName(watcher.CompositionReferenceGroupKind)
 
 	log := o.Logger.WithValues("controller", name)
@@ -62,16 +77,57 @@ func Setup(mgr ctrl.Manager, o controller.Options) error {
 
 	recorder := mgr.GetEventRecorderFor(name)
 
-	inf := &informerHelper.CompositionInformer{}
-	inf.InitCompositionInformer(log)
+	filterCache := filters.NewCache()
+	healthRegistry := health.NewRegistry()
+
+	dynClient, err := dynamic.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return fmt.Errorf("unable to create dynamic client: %w", err)
+	}
+
+	// HEALTH_RULES_CONFIGMAP is the namespace/name of a ConfigMap with user-defined health rules
+	if healthRules := os.Getenv("HEALTH_RULES_CONFIGMAP"); healthRules != "" {
+		namespace, name, found := strings.Cut(healthRules, "/")
+		if !found {
+			return fmt.Errorf("HEALTH_RULES_CONFIGMAP must be in the form namespace/name, got %q", healthRules)
+		}
+		configMap := types.NamespacedName{Namespace: namespace, Name: name}
+		if err := mgr.Add(health.NewScriptLoader(dynClient, configMap, healthRegistry, log)); err != nil {
+			return fmt.Errorf("unable to add health rules loader: %w", err)
+		}
+	}
+
+	// TREE_RESYNC_INTERVAL forces a push of unchanged trees, for handlers that expire their cache
+	var resyncInterval time.Duration
+	if resync := os.Getenv("TREE_RESYNC_INTERVAL"); resync != "" {
+		resyncInterval, err = time.ParseDuration(resync)
+		if err != nil {
+			return fmt.Errorf("unable to parse TREE_RESYNC_INTERVAL: %w", err)
+		}
+	}
+
+	informerOpts, err := informerOptions()
+	if err != nil {
+		return err
+	}
+
+	registry := informerHelper.NewRegistry(log, mgr.GetClient(), mgr.GetAPIReader(), mgr.GetRESTMapper(), dynClient, informerOpts, filterCache, healthRegistry)
+	if err := mgr.Add(manager.RunnableFunc(registry.Run)); err != nil {
+		return fmt.Errorf("unable to add composition informer workers: %w", err)
+	}
+	if err := debug.Setup(mgr, registry, debugOpts, log); err != nil {
+		return fmt.Errorf("unable to add debug endpoints: %w", err)
+	}
 
 	r := reconciler.NewReconciler(mgr,
 		resource.ManagedKind(watcher.CompositionReferenceGroupVersionKind),
 		reconciler.WithExternalConnecter(&connector{
-			compositionInformer: inf,
-			log:                 log,
-			recorder:            recorder,
-			pollInterval:        o.PollInterval,
+			registry:       registry,
+			filters:        filterCache,
+			health:         healthRegistry,
+			log:            log,
+			recorder:       recorder,
+			resyncInterval: resyncInterval,
 		}),
 		reconciler.WithPollInterval(o.PollInterval),
 		reconciler.WithLogger(log),
@@ -82,15 +138,71 @@ func Setup(mgr ctrl.Manager, o controller.Options) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		Named(name).
 		WithOptions(o.ForControllerRuntime()).
-		For(&watcher.CompositionReference{}).
+		For(&watcher.CompositionReference{}, builder.WithPredicates(ignoreStatusUpdates())).
 		Complete(ratelimiter.New(name, r, o.GlobalRateLimiter))
 }
 
+// ignoreStatusUpdates filters out the updates that only change the status, like those written after
+// informer driven pushes, which would otherwise rebuild every tree of the CompositionReference
+func ignoreStatusUpdates() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e ctrlevent.UpdateEvent) bool {
+			if e.ObjectOld == nil || e.ObjectNew == nil {
+				return true
+			}
+			return e.ObjectOld.GetGeneration() != e.ObjectNew.GetGeneration() ||
+				!e.ObjectOld.GetDeletionTimestamp().Equal(e.ObjectNew.GetDeletionTimestamp()) ||
+				!slices.Equal(e.ObjectOld.GetFinalizers(), e.ObjectNew.GetFinalizers()) ||
+				!maps.Equal(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels()) ||
+				!maps.Equal(e.ObjectOld.GetAnnotations(), e.ObjectNew.GetAnnotations())
+		},
+	}
+}
+
+// informerOptions reads the composition informers configuration from the environment:
+//   - INFORMER_SCOPE is "namespace" (default) to share one informer per GVR and namespace,
+//     or "name" to list and watch every object with its own informer
+//   - DEBOUNCE_WINDOW (default 2s) coalesces the events of a composition, 0 disables it
+//   - DEBOUNCE_MAX_WAIT (default 30s) bounds how long the events of a composition are held back
+func informerOptions() (informerHelper.Options, error) {
+	opts := informerHelper.Options{
+		Scope:           informerHelper.ScopeNamespace,
+		DebounceWindow:  2 * time.Second,
+		DebounceMaxWait: 30 * time.Second,
+	}
+
+	switch scope := informerHelper.Scope(os.Getenv("INFORMER_SCOPE")); scope {
+	case "", informerHelper.ScopeNamespace:
+	case informerHelper.ScopeName:
+		opts.Scope = scope
+	default:
+		return opts, fmt.Errorf("INFORMER_SCOPE must be %q or %q, got %q", informerHelper.ScopeNamespace, informerHelper.ScopeName, scope)
+	}
+
+	if window := os.Getenv("DEBOUNCE_WINDOW"); window != "" {
+		d, err := time.ParseDuration(window)
+		if err != nil {
+			return opts, fmt.Errorf("unable to parse DEBOUNCE_WINDOW: %w", err)
+		}
+		opts.DebounceWindow = d
+	}
+	if maxWait := os.Getenv("DEBOUNCE_MAX_WAIT"); maxWait != "" {
+		d, err := time.ParseDuration(maxWait)
+		if err != nil {
+			return opts, fmt.Errorf("unable to parse DEBOUNCE_MAX_WAIT: %w", err)
+		}
+		opts.DebounceMaxWait = d
+	}
+	return opts, nil
+}
+
 type connector struct {
-	compositionInformer *informerHelper.CompositionInformer
-	pollInterval        time.Duration
-	log                 logging.Logger
-	recorder            record.EventRecorder
+	registry       *informerHelper.Registry
+	filters        *filters.Cache
+	health         *health.Registry
+	resyncInterval time.Duration
+	log            logging.Logger
+	recorder       record.EventRecorder
 }
 
 func (c *connector) Connect(ctx context.Context, mg resource.Managed) (reconciler.ExternalClient, error) {
@@ -105,24 +217,27 @@ func (c *connector) Connect(ctx context.Context, mg resource.Managed) (reconcile
 	}
 
 	return &external{
-		cfg:                 cfg,
-		dynClient:           dynClient,
-		compositionInformer: c.compositionInformer,
-		sinceLastUpdate:     make(map[string]time.Time),
-		pollInterval:        c.pollInterval,
-		log:                 c.log,
-		rec:                 c.recorder,
+		dynClient:      dynClient,
+		registry:       c.registry,
+		filters:        c.filters,
+		health:         c.health,
+		resyncInterval: c.resyncInterval,
+		log:            c.log,
+		rec:            c.recorder,
 	}, nil
 }
 
 type external struct {
-	cfg                 *rest.Config
-	compositionInformer *informerHelper.CompositionInformer
-	dynClient           *dynamic.DynamicClient
-	sinceLastUpdate     map[string]time.Time
-	pollInterval        time.Duration
-	log                 logging.Logger
-	rec                 record.EventRecorder
+	registry       *informerHelper.Registry
+	dynClient      dynamic.Interface
+	filters        *filters.Cache
+	health         *health.Registry
+	resyncInterval time.Duration
+	log            logging.Logger
+	rec            record.EventRecorder
+
+	// build holds the trees built by Observe, so that Update pushes them without building them again
+	build *treeBuild
 }
 
 func (c *external) Disconnect(_ context.Context) error {
@@ -135,35 +250,66 @@ func (e *external) Observe(ctx context.Context, mg resource.Managed) (reconciler
 		return reconciler.ExternalObservation{}, errors.New(errNotCompositionReference)
 	}
 
-	obj, err := e.getObj(ctx, cr)
+	compositions, err := e.getCompositions(ctx, cr)
+	if apierrors.IsNotFound(err) && meta.WasDeleted(cr) {
+		// The composition is already gone, the informer has cleared the webservice cache
+		return reconciler.ExternalObservation{
+			ResourceExists: false,
+		}, nil
+	}
 	if err != nil {
 		return reconciler.ExternalObservation{}, err
 	}
 
-	uid := obj.GetUID()
-	if !e.compositionInformer.DoesInformerAlreadyExist(uid) {
+	if _, err := e.filters.Get(cr); err != nil {
+		cr.SetConditions(watcher.FiltersInvalid(err))
+	} else {
+		cr.SetConditions(watcher.FiltersValid())
+	}
+
+	for _, composition := range compositions {
+		if _, ok := e.registry.Get(composition.obj.GetUID()); !ok {
+			return reconciler.ExternalObservation{
+				ResourceExists: false,
+			}, nil
+		}
+	}
+
+	if !isMatchedUpToDate(cr.Status.Matched, compositions) {
 		return reconciler.ExternalObservation{
-			ResourceExists: false,
+			ResourceExists:   true,
+			ResourceUpToDate: false,
 		}, nil
 	}
 
-	timeSinceLastUpdate, ok := e.sinceLastUpdate[cr.Name+cr.Namespace]
-	if !ok {
+	// Spec changes that leave the trees unchanged, like watchedPaths, still need Update to restart the informers
+	if cr.Status.ObservedGeneration != cr.GetGeneration() {
 		return reconciler.ExternalObservation{
 			ResourceExists:   true,
 			ResourceUpToDate: false,
 		}, nil
-	} else {
-		cr.SetConditions(prv1.Available())
-		if time.Since(timeSinceLastUpdate) > e.pollInterval {
-			return reconciler.ExternalObservation{
-				ResourceExists:   true,
-				ResourceUpToDate: false,
-			}, nil
-		}
 	}
 
+	// Build errors are recorded in the status by Update
+	build, err := e.buildTrees(cr, compositions)
+	if err != nil {
+		return reconciler.ExternalObservation{
+			ResourceExists:   true,
+			ResourceUpToDate: false,
+		}, nil
+	}
+	e.build = build
+
 	cr.SetConditions(prv1.Available())
+	// Health changes mostly come through the informers, which push the tree without writing the rollup
+	statusGetter.RecordHealth(cr, build.rollup)
+	if !build.isUpToDate(cr.Status.Matched, e.resyncInterval) {
+		return reconciler.ExternalObservation{
+			ResourceExists:   true,
+			ResourceUpToDate: false,
+		}, nil
+	}
+
 	return reconciler.ExternalObservation{
 		ResourceExists:   true,
 		ResourceUpToDate: true,
@@ -179,18 +325,23 @@ func (e *external) Create(ctx context.Context, mg resource.Managed) error {
 
 	cr.SetConditions(prv1.Creating())
 
-	obj, err := e.getObj(ctx, cr)
+	compositions, err := e.getCompositions(ctx, cr)
 	if err != nil {
 		return err
 	}
 
-	uid := obj.GetUID()
+	for _, composition := range compositions {
+		uid := composition.obj.GetUID()
+		if _, ok := e.registry.Get(uid); ok {
+			continue
+		}
 
-	if err = e.compositionInformer.StartCompositionInformer(*cr, uid, e.cfg); err != nil {
-		return err
-	}
+		if err = e.registry.Start(*cr, composition.reference, uid); err != nil {
+			return err
+		}
 
-	e.rec.Eventf(cr, corev1.EventTypeNormal, "Completed create", "UID '%s'", uid)
+		e.rec.Eventf(cr, corev1.EventTypeNormal, "Completed create", "UID '%s'", uid)
+	}
 
 	return nil
 }
@@ -201,25 +352,65 @@ func (e *external) Update(ctx context.Context, mg resource.Managed) error {
 		return errors.New(errNotCompositionReference)
 	}
 
-	obj, err := e.getObj(ctx, cr)
+	compositions, err := e.getCompositions(ctx, cr)
 	if err != nil {
 		return err
 	}
 
-	uid := obj.GetUID()
+	build := e.build
+	if build == nil || !isMatchedUpToDate(toMatchedCompositions(build.compositions()), compositions) {
+		build, err = e.buildTrees(cr, compositions)
+		if err != nil {
+			statusGetter.RecordSync(&cr.Status, nil, err)
+			return err
+		}
+	}
 
-	updatedData, err := statusGetter.GetCompositionResourcesStatus(e.dynClient, obj, cr.Spec.Reference, cr.Spec.Filters.Exclude, e.log)
-	if err != nil {
-		return fmt.Errorf("error retrieving updated status information for resources of composition uid %s: %w", uid, err)
+	total := &statusGetter.TreeSummary{}
+	matched := toMatchedCompositions(build.compositions())
+	for i, tree := range build.trees {
+		uid := tree.composition.obj.GetUID()
+		total.Add(tree.summary)
+
+		// The informer handlers use the CompositionReference they were started with
+		if entry, ok := e.registry.Get(uid); ok && entry.Generation != cr.GetGeneration() {
+			if err := e.registry.Restart(*cr, tree.composition.reference, uid); err != nil {
+				e.log.Info(fmt.Sprintf("unable to restart informer: %s", err), "UID", uid)
+			}
+		}
+
+		// Only the trees that changed since they were last pushed are sent
+		if j := slices.IndexFunc(cr.Status.Matched, func(m watcher.MatchedComposition) bool { return m.UID == string(uid) }); j >= 0 && tree.isUpToDate(cr.Status.Matched[j], e.resyncInterval) {
+			matched[i].TreeHash = cr.Status.Matched[j].TreeHash
+			matched[i].LastSyncTime = cr.Status.Matched[j].LastSyncTime
+			continue
+		}
+
+		err = httpHelper.SendTree(string(uid), tree.data)
+		if err != nil {
+			err = fmt.Errorf("error with requested http resource: %w", err)
+			statusGetter.RecordSync(&cr.Status, nil, err)
+			return err
+		}
+
+		now := metav1.Now()
+		matched[i].TreeHash = tree.summary.Hash
+		matched[i].LastSyncTime = &now
+
+		e.rec.Eventf(cr, corev1.EventTypeNormal, "Completed update", "UID '%s'", uid)
 	}
 
-	err = httpHelper.Request("POST", fmt.Sprintf("/compositions/%s", uid), updatedData)
-	if err != nil {
-		return fmt.Errorf("error with requested http resource: %w", err)
+	e.pruneMatched(cr, compositions)
+	cr.Status.Matched = matched
+
+	cr.Status.ObservedGeneration = cr.GetGeneration()
+	cr.Status.CompositionUID = ""
+	if len(compositions) == 1 {
+		cr.Status.CompositionUID = string(compositions[0].obj.GetUID())
 	}
+	statusGetter.RecordSync(&cr.Status, total, nil)
+	statusGetter.RecordHealth(cr, build.rollup)
 
-	e.rec.Eventf(cr, corev1.EventTypeNormal, "Completed update", "UID '%s'", uid)
-	e.sinceLastUpdate[cr.Name+cr.Namespace] = time.Now()
 	return nil
 }
 
@@ -231,54 +422,204 @@ func (e *external) Delete(ctx context.Context, mg resource.Managed) error {
 
 	cr.SetConditions(prv1.Deleting())
 
-	gv, err := schema.ParseGroupVersion(cr.Spec.Reference.ApiVersion)
+	compositions, err := e.getCompositions(ctx, cr)
 	if err != nil {
-		return fmt.Errorf("unable to parse GroupVersion from composition reference ApiVersion: %w", err)
+		return err
 	}
-	gvr := schema.GroupVersionResource{
-		Group:    gv.Group,
-		Version:  gv.Version,
-		Resource: cr.Spec.Reference.Resource,
+
+	deletedUIDs := []types.UID{}
+	for _, matched := range toMatchedCompositions(compositions) {
+		deletedUIDs = append(deletedUIDs, types.UID(matched.UID))
 	}
-	// Get structure to send to webservice
-	compositionObj, err := e.dynClient.Resource(gvr).Namespace(cr.Spec.Reference.Namespace).Get(context.Background(), cr.Spec.Reference.Name, metav1.GetOptions{})
-	if err != nil {
-		return fmt.Errorf("unable to retrieve composition object: %w", err)
+	for _, matched := range cr.Status.Matched {
+		if !slices.Contains(deletedUIDs, types.UID(matched.UID)) {
+			deletedUIDs = append(deletedUIDs, types.UID(matched.UID))
+		}
 	}
 
-	deletedUID := compositionObj.GetUID()
+	for _, deletedUID := range deletedUIDs {
+		err = httpHelper.DeleteTree(string(deletedUID))
+		if err != nil {
+			return fmt.Errorf("error with requested http resource: %w", err)
+		}
+
+		if !e.registry.Stop(deletedUID) {
+			e.log.Info("Could not delete informer for composotion", "uid", deletedUID)
+		}
+
+		e.log.Debug("Deleted cache on webservice", "delete UID", deletedUID)
+		e.rec.Eventf(cr, corev1.EventTypeNormal, "Deleted from cache", "UID '%s'", deletedUID)
+	}
+
+	e.filters.Delete(cr.GetUID())
+
+	return nil
+}
+
+// pruneMatched stops tracking the compositions that were previously matched but are not anymore
+func (e *external) pruneMatched(cr *watcher.CompositionReference, compositions []trackedComposition) {
+	current := toMatchedCompositions(compositions)
+	for _, matched := range cr.Status.Matched {
+		if slices.ContainsFunc(current, func(m watcher.MatchedComposition) bool { return m.UID == matched.UID }) {
+			continue
+		}
+
+		uid := types.UID(matched.UID)
+		// The informer delete handler already cleared the webservice cache if the informer is gone
+		if !e.registry.Stop(uid) {
+			continue
+		}
+
+		err := httpHelper.DeleteTree(string(uid))
+		if err != nil {
+			e.log.Info(fmt.Sprintf("error with requested http resource: %s", err))
+			continue
+		}
+		e.rec.Eventf(cr, corev1.EventTypeNormal, "Deleted from cache", "UID '%s'", uid)
+	}
+}
+
+// builtTree is the tree of a composition, ready to be pushed
+type builtTree struct {
+	composition trackedComposition
+	data        []byte
+	summary     *statusGetter.TreeSummary
+}
+
+// treeBuild holds the trees of all the compositions tracked by a CompositionReference
+type treeBuild struct {
+	trees  []builtTree
+	rollup *health.Rollup
+}
 
-	err = httpHelper.Request("DELETE", fmt.Sprintf("/compositions/%s", deletedUID), nil)
+func (e *external) buildTrees(cr *watcher.CompositionReference, compositions []trackedComposition) (*treeBuild, error) {
+	engine, err := e.filters.Get(cr)
 	if err != nil {
-		return fmt.Errorf("error with requested http resource: %w", err)
+		cr.SetConditions(watcher.FiltersInvalid(err))
+	} else {
+		cr.SetConditions(watcher.FiltersValid())
 	}
 
-	if !e.compositionInformer.DeleteInformer(deletedUID) {
-		e.log.Info("Could not delete informer for composotion", "uid", deletedUID)
+	build := &treeBuild{
+		trees:  make([]builtTree, 0, len(compositions)),
+		rollup: health.NewRollup(statusGetter.HealthWeights(cr)),
+	}
+	for _, composition := range compositions {
+		data, summary, err := statusGetter.GetCompositionResourcesStatus(e.dynClient, composition.obj, composition.reference, engine, cr.Spec.Children, e.health, build.rollup, e.log)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving updated status information for resources of composition uid %s: %w", composition.obj.GetUID(), err)
+		}
+		build.trees = append(build.trees, builtTree{composition: composition, data: data, summary: summary})
+	}
+	return build, nil
+}
+
+func (b *treeBuild) compositions() []trackedComposition {
+	res := make([]trackedComposition, 0, len(b.trees))
+	for _, tree := range b.trees {
+		res = append(res, tree.composition)
 	}
+	return res
+}
 
-	delete(e.sinceLastUpdate, cr.Name+cr.Namespace)
+// isUpToDate reports whether every tree matches the last one pushed. When a resync interval
+// is set, trees pushed longer ago are pushed again even if unchanged.
+func (b *treeBuild) isUpToDate(matched []watcher.MatchedComposition, resyncInterval time.Duration) bool {
+	for _, tree := range b.trees {
+		i := slices.IndexFunc(matched, func(m watcher.MatchedComposition) bool { return m.UID == string(tree.composition.obj.GetUID()) })
+		if i < 0 || !tree.isUpToDate(matched[i], resyncInterval) {
+			return false
+		}
+	}
+	return true
+}
 
-	e.log.Debug("Deleted cache on webservice", "delete UID", deletedUID)
-	e.rec.Eventf(cr, corev1.EventTypeNormal, "Deleted from cache", "UID '%s'", deletedUID)
+// isUpToDate reports whether the tree matches the last one pushed for its composition
+func (t builtTree) isUpToDate(matched watcher.MatchedComposition, resyncInterval time.Duration) bool {
+	if matched.TreeHash != t.summary.Hash || matched.LastSyncTime == nil {
+		return false
+	}
+	return resyncInterval <= 0 || time.Since(matched.LastSyncTime.Time) <= resyncInterval
+}
 
-	return nil
+// trackedComposition is a composition object together with the reference used to reach it,
+// taken either from spec.reference or built from spec.selector
+type trackedComposition struct {
+	obj       *unstructured.Unstructured
+	reference watcher.Reference
 }
 
-func (e *external) getObj(ctx context.Context, cr *watcher.CompositionReference) (*unstructured.Unstructured, error) {
-	gv, err := schema.ParseGroupVersion(cr.Spec.Reference.ApiVersion)
+func (e *external) getCompositions(ctx context.Context, cr *watcher.CompositionReference) ([]trackedComposition, error) {
+	if cr.Spec.Selector != nil {
+		list, err := statusGetter.ListCompositions(ctx, e.dynClient, *cr.Spec.Selector)
+		if err != nil {
+			return nil, err
+		}
+
+		res := make([]trackedComposition, 0, len(list))
+		for i := range list {
+			res = append(res, trackedComposition{
+				obj: &list[i],
+				reference: watcher.Reference{
+					ApiVersion: cr.Spec.Selector.ApiVersion,
+					Resource:   cr.Spec.Selector.Resource,
+					Name:       list[i].GetName(),
+					Namespace:  list[i].GetNamespace(),
+				},
+			})
+		}
+		return res, nil
+	}
+
+	if cr.Spec.Reference == nil {
+		return nil, errors.New(errMissingReference)
+	}
+
+	obj, err := e.getObj(ctx, *cr.Spec.Reference)
+	if err != nil {
+		return nil, err
+	}
+	return []trackedComposition{{obj: obj, reference: *cr.Spec.Reference}}, nil
+}
+
+func (e *external) getObj(ctx context.Context, reference watcher.Reference) (*unstructured.Unstructured, error) {
+	gv, err := schema.ParseGroupVersion(reference.ApiVersion)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse GroupVersion from composition reference ApiVersion: %w", err)
 	}
 	gvr := schema.GroupVersionResource{
 		Group:    gv.Group,
 		Version:  gv.Version,
-		Resource: cr.Spec.Reference.Resource,
+		Resource: reference.Resource,
 	}
 	// Get structure to send to webservice
-	res, err := e.dynClient.Resource(gvr).Namespace(cr.Spec.Reference.Namespace).Get(ctx, cr.Spec.Reference.Name, metav1.GetOptions{})
+	res, err := e.dynClient.Resource(gvr).Namespace(reference.Namespace).Get(ctx, reference.Name, metav1.GetOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("unable to retrieve resource %s with name %s in namespace %s, with apiVersion %s: %w", cr.Spec.Reference.Resource, cr.Spec.Reference.Name, cr.Spec.Reference.Namespace, cr.Spec.Reference.ApiVersion, err)
+		return nil, fmt.Errorf("unable to retrieve resource %s with name %s in namespace %s, with apiVersion %s: %w", reference.Resource, reference.Name, reference.Namespace, reference.ApiVersion, err)
 	}
 	return res, nil
 }
+
+func toMatchedCompositions(compositions []trackedComposition) []watcher.MatchedComposition {
+	res := make([]watcher.MatchedComposition, 0, len(compositions))
+	for _, composition := range compositions {
+		res = append(res, watcher.MatchedComposition{
+			Reference: composition.reference,
+			UID:       string(composition.obj.GetUID()),
+		})
+	}
+	return res
+}
+
+func isMatchedUpToDate(matched []watcher.MatchedComposition, compositions []trackedComposition) bool {
+	if len(matched) != len(compositions) {
+		return false
+	}
+	current := toMatchedCompositions(compositions)
+	for _, m := range matched {
+		if !slices.ContainsFunc(current, func(c watcher.MatchedComposition) bool { return c.UID == m.UID && c.Reference == m.Reference }) {
+			return false
+		}
+	}
+	return true
+}
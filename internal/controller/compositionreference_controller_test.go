@@ -0,0 +1,92 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	watcher "github.com/krateoplatformops/composition-watcher/api/v1"
+	"github.com/krateoplatformops/composition-watcher/internal/helpers/filters"
+	"github.com/krateoplatformops/composition-watcher/internal/helpers/health"
+	informerHelper "github.com/krateoplatformops/composition-watcher/internal/helpers/informer"
+)
+
+func TestObserveWatchedPathsChange(t *testing.T) {
+	compositionGVR := schema.GroupVersionResource{Group: "composition.krateo.io", Version: "v1", Resource: "fireworksapps"}
+	composition := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "composition.krateo.io/v1",
+		"kind":       "FireworksApp",
+		"metadata": map[string]interface{}{
+			"name":      "app",
+			"namespace": "demo",
+			"uid":       "composition-uid",
+		},
+		"status": map[string]interface{}{
+			"managed": []interface{}{},
+		},
+	}}
+	reference := watcher.Reference{ApiVersion: "composition.krateo.io/v1", Resource: "fireworksapps", Name: "app", Namespace: "demo"}
+
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{compositionGVR: "FireworksAppList"}, composition)
+
+	log := logging.NewNopLogger()
+	filterCache := filters.NewCache()
+	healthRegistry := health.NewRegistry()
+	registry := informerHelper.NewRegistry(log, nil, nil, nil, dynClient, informerHelper.Options{Scope: informerHelper.ScopeNamespace}, filterCache, healthRegistry)
+
+	cr := &watcher.CompositionReference{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "demo", UID: "reference-uid", Generation: 1},
+		Spec:       watcher.CompositionReferenceSpec{Reference: &reference},
+	}
+	if err := registry.Start(*cr, reference, composition.GetUID()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer registry.Stop(composition.GetUID())
+
+	e := &external{
+		registry:  registry,
+		dynClient: dynClient,
+		filters:   filterCache,
+		health:    healthRegistry,
+		log:       log,
+	}
+
+	// The first observation builds the tree, which is then recorded as pushed
+	cr.Status.Matched = []watcher.MatchedComposition{{Reference: reference, UID: string(composition.GetUID())}}
+	cr.Status.ObservedGeneration = cr.GetGeneration()
+	if _, err := e.Observe(context.Background(), cr); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if e.build == nil || len(e.build.trees) != 1 {
+		t.Fatal("expected the tree of the composition to be built")
+	}
+	now := metav1.Now()
+	cr.Status.Matched[0].TreeHash = e.build.trees[0].summary.Hash
+	cr.Status.Matched[0].LastSyncTime = &now
+
+	obs, err := e.Observe(context.Background(), cr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !obs.ResourceExists || !obs.ResourceUpToDate {
+		t.Fatalf("expected the pushed tree to be up to date, got %+v", obs)
+	}
+
+	// Changing watchedPaths leaves the tree unchanged, the informers still need to be restarted
+	cr.Spec.WatchedPaths = []string{"spec.replicas"}
+	cr.SetGeneration(2)
+	obs, err = e.Observe(context.Background(), cr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !obs.ResourceExists || obs.ResourceUpToDate {
+		t.Fatalf("expected a spec change not to be up to date, got %+v", obs)
+	}
+}
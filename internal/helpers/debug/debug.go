@@ -0,0 +1,226 @@
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	watcher "github.com/krateoplatformops/composition-watcher/api/v1"
+	httpHelper "github.com/krateoplatformops/composition-watcher/internal/helpers/http"
+	informerHelper "github.com/krateoplatformops/composition-watcher/internal/helpers/informer"
+)
+
+const (
+	informersPath    = "/debug/informers"
+	compositionsPath = "/debug/compositions/{uid}"
+)
+
+// Options describes how the metrics server of the manager is exposed
+type Options struct {
+	// BindAddress is the address the metrics server listens on
+	BindAddress string
+	// SecureServing is set when the metrics server is served over HTTPS
+	SecureServing bool
+}
+
+// Setup serves the state of the composition informers on the metrics server of the manager. The
+// endpoints check the bearer token of the requests, so they are only served over HTTPS. A metrics
+// server bound to the loopback interface is reached through kube-rbac-proxy, which has already
+// checked the token and removed it from the request. Otherwise the endpoints are not served.
+func Setup(mgr ctrl.Manager, registry *informerHelper.Registry, opts Options, log logging.Logger) error {
+	h := &handlers{registry: registry, client: mgr.GetClient()}
+
+	var wrap func(http.Handler) http.Handler
+	switch {
+	case opts.SecureServing:
+		wrap = func(next http.Handler) http.Handler { return authenticated(mgr.GetClient(), next) }
+	case isLoopback(opts.BindAddress):
+		wrap = func(next http.Handler) http.Handler { return next }
+	default:
+		log.Info("debug endpoints disabled, the metrics server is neither served securely nor bound to the loopback interface", "address", opts.BindAddress)
+		return nil
+	}
+
+	if err := mgr.AddMetricsServerExtraHandler(informersPath, wrap(http.HandlerFunc(h.informers))); err != nil {
+		return fmt.Errorf("unable to add %s handler: %w", informersPath, err)
+	}
+	if err := mgr.AddMetricsServerExtraHandler(compositionsPath, wrap(http.HandlerFunc(h.composition))); err != nil {
+		return fmt.Errorf("unable to add %s handler: %w", compositionsPath, err)
+	}
+	return nil
+}
+
+// isLoopback reports whether the address only listens on the loopback interface
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+type handlers struct {
+	registry *informerHelper.Registry
+	client   client.Client
+}
+
+type informersResponse struct {
+	Informers    []informerStatus    `json:"informers"`
+	Compositions []compositionStatus `json:"compositions"`
+}
+
+type informerStatus struct {
+	GVR         string           `json:"gvr"`
+	Namespace   string           `json:"namespace,omitempty"`
+	Name        string           `json:"name,omitempty"`
+	HasSynced   bool             `json:"hasSynced"`
+	Objects     int              `json:"objects"`
+	Subscribers []subscriberInfo `json:"subscribers"`
+}
+
+type subscriberInfo struct {
+	UID                  types.UID `json:"uid"`
+	CompositionReference string    `json:"compositionReference,omitempty"`
+}
+
+type compositionStatus struct {
+	UID                  types.UID `json:"uid"`
+	GVR                  string    `json:"gvr"`
+	Namespace            string    `json:"namespace"`
+	Name                 string    `json:"name"`
+	CompositionReference string    `json:"compositionReference"`
+	Generation           int64     `json:"generation"`
+	ManagedResources     int       `json:"managedResources"`
+	HasSynced            bool      `json:"hasSynced"`
+	StartTime            time.Time `json:"startTime"`
+	// LastEventTime is the last informer event of the composition or of its managed resources
+	LastEventTime *time.Time `json:"lastEventTime,omitempty"`
+	// TreeHash and LastSyncTime are those of the last tree sent, by the informers or the reconciler
+	TreeHash     string     `json:"treeHash,omitempty"`
+	LastSyncTime *time.Time `json:"lastSyncTime,omitempty"`
+	// LastPush is the last informer driven push
+	LastPush *pushStatus `json:"lastPush,omitempty"`
+	// LastRequestError is the last failed request to the webservice
+	LastRequestError *requestError `json:"lastRequestError,omitempty"`
+}
+
+type pushStatus struct {
+	Time     time.Time `json:"time"`
+	TreeHash string    `json:"treeHash,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type requestError struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	Error  string    `json:"error"`
+}
+
+// informers lists the running shared informers and the tracked compositions
+func (h *handlers) informers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	entries := h.registry.List()
+	owners := make(map[types.UID]string, len(entries))
+	res := informersResponse{
+		Informers:    []informerStatus{},
+		Compositions: make([]compositionStatus, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		owners[entry.UID] = entry.CompositionReference.String()
+		res.Compositions = append(res.Compositions, h.compositionStatus(r.Context(), entry))
+	}
+
+	for _, informer := range h.registry.Informers() {
+		status := informerStatus{
+			GVR:         informer.GVR.String(),
+			Namespace:   informer.Namespace,
+			Name:        informer.Name,
+			HasSynced:   informer.HasSynced,
+			Objects:     informer.Objects,
+			Subscribers: make([]subscriberInfo, 0, len(informer.Subscribers)),
+		}
+		for _, uid := range informer.Subscribers {
+			status.Subscribers = append(status.Subscribers, subscriberInfo{UID: uid, CompositionReference: owners[uid]})
+		}
+		res.Informers = append(res.Informers, status)
+	}
+
+	writeJSON(w, res)
+}
+
+// composition shows a single tracked composition
+func (h *handlers) composition(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	entry, ok := h.registry.Get(types.UID(r.PathValue("uid")))
+	if !ok {
+		http.Error(w, "composition not tracked", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, h.compositionStatus(r.Context(), entry))
+}
+
+func (h *handlers) compositionStatus(ctx context.Context, entry informerHelper.Entry) compositionStatus {
+	res := compositionStatus{
+		UID:                  entry.UID,
+		GVR:                  entry.GVR.String(),
+		Namespace:            entry.Namespace,
+		Name:                 entry.Name,
+		CompositionReference: entry.CompositionReference.String(),
+		Generation:           entry.Generation,
+		ManagedResources:     entry.Managed,
+		HasSynced:            entry.HasSynced,
+		StartTime:            entry.StartTime,
+	}
+	if !entry.LastEventTime.IsZero() {
+		res.LastEventTime = &entry.LastEventTime
+	}
+	if push := entry.LastPush; push != nil {
+		res.LastPush = &pushStatus{Time: push.Time, TreeHash: push.TreeHash, Error: push.Error}
+	}
+	if lastError, ok := httpHelper.LastError(string(entry.UID)); ok {
+		res.LastRequestError = &requestError{Time: lastError.Time, Method: lastError.Method, Error: lastError.Error}
+	}
+
+	// The reconciler records the hash of the trees it sends in the status of the CompositionReference
+	cr := &watcher.CompositionReference{}
+	if err := h.client.Get(ctx, entry.CompositionReference, cr); err == nil {
+		for _, matched := range cr.Status.Matched {
+			if matched.UID != string(entry.UID) {
+				continue
+			}
+			res.TreeHash = matched.TreeHash
+			if matched.LastSyncTime != nil {
+				res.LastSyncTime = &matched.LastSyncTime.Time
+			}
+		}
+	}
+	return res
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
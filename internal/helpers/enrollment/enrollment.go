@@ -0,0 +1,314 @@
+package enrollment
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/krateoplatformops/provider-runtime/pkg/controller"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	watcher "github.com/krateoplatformops/composition-watcher/api/v1"
+)
+
+const (
+	// LabelAutoEnrolled marks the CompositionReferences generated by the enroller
+	LabelAutoEnrolled = "resourcetrees.krateo.io/auto-enrolled"
+)
+
+var compositionDefinitionsGVR = schema.GroupVersionResource{
+	Group:    "core.krateo.io",
+	Version:  "v1alpha1",
+	Resource: "compositiondefinitions",
+}
+
+// Options configures which compositions are enrolled automatically
+type Options struct {
+	// AllowedNamespaces lists the namespaces whose compositions are enrolled, all namespaces are allowed when empty
+	AllowedNamespaces []string
+	// ExcludedNamespaces lists the namespaces whose compositions are never enrolled, it takes precedence over AllowedNamespaces
+	ExcludedNamespaces []string
+}
+
+// Enroller watches CompositionDefinitions and creates a CompositionReference for
+// every composition instance of the generated resources, deleting it when the
+// composition goes away
+type Enroller struct {
+	client    client.Client
+	dynClient dynamic.Interface
+	mapper    meta.RESTMapper
+	opts      Options
+	logger    logging.Logger
+
+	mu          sync.Mutex
+	definitions map[types.UID]schema.GroupVersionResource
+	stopChans   map[schema.GroupVersionResource]chan struct{}
+}
+
+func Setup(mgr ctrl.Manager, o controller.Options, opts Options) error {
+	dynClient, err := dynamic.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return fmt.Errorf("unable to create dynamic client: %w", err)
+	}
+
+	log := o.Logger.WithValues("runnable", "enrollment")
+	log.Info("automatic enrollment enabled", "allowed", opts.AllowedNamespaces, "excluded", opts.ExcludedNamespaces)
+
+	return mgr.Add(&Enroller{
+		client:      mgr.GetClient(),
+		dynClient:   dynClient,
+		mapper:      mgr.GetRESTMapper(),
+		opts:        opts,
+		logger:      log,
+		definitions: make(map[types.UID]schema.GroupVersionResource),
+		stopChans:   make(map[schema.GroupVersionResource]chan struct{}),
+	})
+}
+
+// Start runs the CompositionDefinition informer until the context is cancelled
+func (e *Enroller) Start(ctx context.Context) error {
+	fac := dynamicinformer.NewDynamicSharedInformerFactory(e.dynClient, 0)
+	informer := fac.ForResource(compositionDefinitionsGVR).Informer()
+
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			e.onDefinition(ctx, obj)
+		},
+		UpdateFunc: func(_ interface{}, newObj interface{}) {
+			e.onDefinition(ctx, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			e.onDefinitionDeleted(obj)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to add compositiondefinitions event handler: %w", err)
+	}
+
+	informer.Run(ctx.Done())
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for gvr, stopChan := range e.stopChans {
+		close(stopChan)
+		delete(e.stopChans, gvr)
+	}
+	return nil
+}
+
+func (e *Enroller) onDefinition(ctx context.Context, obj interface{}) {
+	item, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	gvr, ok := e.resolveDefinition(item)
+	if !ok {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// A new version of the definition generates another resource, the informer of the old one may not be needed anymore
+	previous, ok := e.definitions[item.GetUID()]
+	e.definitions[item.GetUID()] = gvr
+	if ok && previous != gvr {
+		e.stopUnused(previous)
+	}
+	if _, ok := e.stopChans[gvr]; ok {
+		return
+	}
+
+	fac := dynamicinformer.NewDynamicSharedInformerFactory(e.dynClient, 0)
+	informer := fac.ForResource(gvr).Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if composition, ok := obj.(*unstructured.Unstructured); ok {
+				e.enroll(ctx, gvr, composition)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if composition, ok := obj.(*unstructured.Unstructured); ok {
+				e.unenroll(ctx, gvr, composition)
+			}
+		},
+	})
+	if err != nil {
+		e.logger.Info(fmt.Sprintf("unable to add compositions event handler: %s", err), "gvr", gvr.String())
+		return
+	}
+
+	stopChan := make(chan struct{})
+	e.stopChans[gvr] = stopChan
+	go informer.Run(stopChan)
+	e.logger.Info("Started enrollment informer", "gvr", gvr.String())
+}
+
+func (e *Enroller) onDefinitionDeleted(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	item, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	gvr, ok := e.definitions[item.GetUID()]
+	if !ok {
+		return
+	}
+	delete(e.definitions, item.GetUID())
+	e.stopUnused(gvr)
+}
+
+// stopUnused stops the informer of a resource that no definition generates anymore, the caller holds the lock
+func (e *Enroller) stopUnused(gvr schema.GroupVersionResource) {
+	// Other definitions may still generate the same resource
+	for _, other := range e.definitions {
+		if other == gvr {
+			return
+		}
+	}
+
+	if stopChan, ok := e.stopChans[gvr]; ok {
+		close(stopChan)
+		delete(e.stopChans, gvr)
+		e.logger.Info("Stopped enrollment informer", "gvr", gvr.String())
+	}
+}
+
+// resolveDefinition returns the resource generated by a CompositionDefinition,
+// which is only known once the definition has been reconciled by the core-provider
+func (e *Enroller) resolveDefinition(definition *unstructured.Unstructured) (schema.GroupVersionResource, bool) {
+	apiVersion, _, _ := unstructured.NestedString(definition.Object, "status", "apiVersion")
+	kind, _, _ := unstructured.NestedString(definition.Object, "status", "kind")
+	if apiVersion == "" || kind == "" {
+		return schema.GroupVersionResource{}, false
+	}
+
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		e.logger.Info(fmt.Sprintf("unable to parse GroupVersion of compositiondefinition: %s", err), "name", definition.GetName(), "namespace", definition.GetNamespace())
+		return schema.GroupVersionResource{}, false
+	}
+
+	if resource, _, _ := unstructured.NestedString(definition.Object, "status", "resource"); resource != "" {
+		return gv.WithResource(resource), true
+	}
+
+	mapping, err := e.mapper.RESTMapping(gv.WithKind(kind).GroupKind(), gv.Version)
+	if err != nil {
+		e.logger.Debug("unable to map compositiondefinition kind to resource", "error", err, "apiVersion", apiVersion, "kind", kind)
+		return schema.GroupVersionResource{}, false
+	}
+	return mapping.Resource, true
+}
+
+func (e *Enroller) isNamespaceAllowed(namespace string) bool {
+	if slices.Contains(e.opts.ExcludedNamespaces, namespace) {
+		return false
+	}
+	return len(e.opts.AllowedNamespaces) == 0 || slices.Contains(e.opts.AllowedNamespaces, namespace)
+}
+
+func (e *Enroller) enroll(ctx context.Context, gvr schema.GroupVersionResource, composition *unstructured.Unstructured) {
+	if !e.isNamespaceAllowed(composition.GetNamespace()) {
+		return
+	}
+
+	cr := &watcher.CompositionReference{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      compositionReferenceName(gvr, composition),
+			Namespace: composition.GetNamespace(),
+			Labels: map[string]string{
+				LabelAutoEnrolled: "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: composition.GetAPIVersion(),
+					Kind:       composition.GetKind(),
+					Name:       composition.GetName(),
+					UID:        composition.GetUID(),
+				},
+			},
+		},
+		Spec: watcher.CompositionReferenceSpec{
+			Filters: watcher.Filters{
+				Exclude: []watcher.FilterRule{},
+			},
+			Reference: &watcher.Reference{
+				ApiVersion: gvr.GroupVersion().String(),
+				Resource:   gvr.Resource,
+				Name:       composition.GetName(),
+				Namespace:  composition.GetNamespace(),
+			},
+		},
+	}
+
+	err := e.client.Create(ctx, cr)
+	if apierrors.IsAlreadyExists(err) {
+		existing := &watcher.CompositionReference{}
+		if err := e.client.Get(ctx, client.ObjectKeyFromObject(cr), existing); err == nil && !isEnrolledFor(existing, composition) {
+			e.logger.Info("unable to enroll composition, a compositionreference with the same name tracks another composition", "UID", composition.GetUID(), "name", cr.Name, "namespace", cr.Namespace)
+		}
+		return
+	}
+	if err != nil {
+		e.logger.Info(fmt.Sprintf("unable to create compositionreference: %s", err), "name", cr.Name, "namespace", cr.Namespace)
+		return
+	}
+	e.logger.Info("Enrolled composition", "UID", composition.GetUID(), "compositionreference", cr.Name, "namespace", cr.Namespace)
+}
+
+func (e *Enroller) unenroll(ctx context.Context, gvr schema.GroupVersionResource, composition *unstructured.Unstructured) {
+	cr := &watcher.CompositionReference{}
+	err := e.client.Get(ctx, client.ObjectKey{Name: compositionReferenceName(gvr, composition), Namespace: composition.GetNamespace()}, cr)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			e.logger.Info(fmt.Sprintf("unable to get compositionreference: %s", err), "UID", composition.GetUID())
+		}
+		return
+	}
+
+	// Never delete CompositionReferences written by hand, or enrolled for another composition
+	if !isEnrolledFor(cr, composition) {
+		return
+	}
+
+	if err := e.client.Delete(ctx, cr); client.IgnoreNotFound(err) != nil {
+		e.logger.Info(fmt.Sprintf("unable to delete compositionreference: %s", err), "name", cr.Name, "namespace", cr.Namespace)
+		return
+	}
+	e.logger.Info("Unenrolled composition", "UID", composition.GetUID(), "compositionreference", cr.Name, "namespace", cr.Namespace)
+}
+
+// compositionReferenceName includes the group, so that resources with the same plural in different groups do not collide
+func compositionReferenceName(gvr schema.GroupVersionResource, composition *unstructured.Unstructured) string {
+	return fmt.Sprintf("%s-%s", composition.GetName(), gvr.GroupResource().String())
+}
+
+// isEnrolledFor reports whether a CompositionReference was enrolled for the composition
+func isEnrolledFor(cr *watcher.CompositionReference, composition *unstructured.Unstructured) bool {
+	return cr.Labels[LabelAutoEnrolled] == "true" && slices.ContainsFunc(cr.OwnerReferences, func(o metav1.OwnerReference) bool {
+		return o.UID == composition.GetUID()
+	})
+}
@@ -0,0 +1,72 @@
+package filters
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	watcher "github.com/krateoplatformops/composition-watcher/api/v1"
+)
+
+// Cache keeps the compiled engines of every CompositionReference by generation. The reconciler
+// and the informers may briefly use different generations, until the informers are restarted, so
+// the previous generation is kept as well and neither of them compiles the filters again.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[types.UID]map[int64]cacheEntry
+}
+
+type cacheEntry struct {
+	engine *Engine
+	err    error
+}
+
+func NewCache() *Cache {
+	return &Cache{
+		entries: make(map[types.UID]map[int64]cacheEntry),
+	}
+}
+
+// Get returns the engine for the CompositionReference together with the compile
+// errors of its filters, compiling them only on the first call for a generation
+func (c *Cache) Get(cr *watcher.CompositionReference) (*Engine, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	generations, ok := c.entries[cr.GetUID()]
+	if !ok {
+		generations = make(map[int64]cacheEntry)
+		c.entries[cr.GetUID()] = generations
+	}
+	if entry, ok := generations[cr.GetGeneration()]; ok {
+		return entry.engine, entry.err
+	}
+
+	engine, err := Compile(cr.Spec.Filters)
+	generations[cr.GetGeneration()] = cacheEntry{
+		engine: engine,
+		err:    err,
+	}
+	prune(generations)
+	return engine, err
+}
+
+// prune keeps the two latest generations
+func prune(generations map[int64]cacheEntry) {
+	for len(generations) > 2 {
+		oldest := int64(-1)
+		for generation := range generations {
+			if oldest < 0 || generation < oldest {
+				oldest = generation
+			}
+		}
+		delete(generations, oldest)
+	}
+}
+
+// Delete forgets the engine of a CompositionReference
+func (c *Cache) Delete(uid types.UID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, uid)
+}
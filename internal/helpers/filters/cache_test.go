@@ -0,0 +1,42 @@
+package filters
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	watcher "github.com/krateoplatformops/composition-watcher/api/v1"
+)
+
+func TestCacheKeepsPreviousGeneration(t *testing.T) {
+	cache := NewCache()
+	generation := func(g int64) *watcher.CompositionReference {
+		return &watcher.CompositionReference{ObjectMeta: metav1.ObjectMeta{UID: "uid", Generation: g}}
+	}
+
+	first, _ := cache.Get(generation(1))
+	second, _ := cache.Get(generation(2))
+	if first == second {
+		t.Fatal("expected a new generation to be compiled")
+	}
+
+	// The informers keep using the previous generation until they are restarted
+	for i := 0; i < 3; i++ {
+		if engine, _ := cache.Get(generation(1)); engine != first {
+			t.Fatal("expected the previous generation not to be compiled again")
+		}
+		if engine, _ := cache.Get(generation(2)); engine != second {
+			t.Fatal("expected the latest generation not to be compiled again")
+		}
+	}
+
+	third, _ := cache.Get(generation(3))
+	if engine, _ := cache.Get(generation(1)); engine == first {
+		t.Fatal("expected the older generations to be dropped")
+	}
+
+	cache.Delete("uid")
+	if engine, _ := cache.Get(generation(3)); engine == third {
+		t.Fatal("expected all the generations to be dropped")
+	}
+}
@@ -0,0 +1,43 @@
+package filters
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+)
+
+// compileExpression compiles a CEL expression evaluated against the fetched
+// resource, exposed to the expression as the "object" variable
+func compileExpression(expression string) (cel.Program, error) {
+	env, err := cel.NewEnv(cel.Variable("object", cel.MapType(cel.StringType, cel.DynType)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create CEL environment: %w", err)
+	}
+
+	ast, iss := env.Compile(expression)
+	if iss.Err() != nil {
+		return nil, fmt.Errorf("unable to compile expression %q: %w", expression, iss.Err())
+	}
+	if !ast.OutputType().IsExactType(cel.BoolType) && !ast.OutputType().IsExactType(cel.DynType) {
+		return nil, fmt.Errorf("expression %q must evaluate to a bool, got %s", expression, ast.OutputType())
+	}
+
+	prg, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build program for expression %q: %w", expression, err)
+	}
+	return prg, nil
+}
+
+// evalExpression runs a compiled expression against the resource object
+func evalExpression(prg cel.Program, obj map[string]interface{}) (bool, error) {
+	out, _, err := prg.Eval(map[string]interface{}{"object": obj})
+	if err != nil {
+		return false, err
+	}
+	res, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("expression returned %T instead of bool", out.Value())
+	}
+	return res, nil
+}
@@ -0,0 +1,249 @@
+package filters
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+
+	watcher "github.com/krateoplatformops/composition-watcher/api/v1"
+)
+
+// Engine evaluates the filters of a CompositionReference. Patterns, label
+// selectors and expressions are compiled once when the engine is built.
+type Engine struct {
+	include []rule
+	exclude []rule
+	// includeInvalid is set when an include rule failed to compile, the managed
+	// resources are then all filtered out rather than all included
+	includeInvalid bool
+}
+
+// matcher reports whether a field value matches a compiled pattern
+type matcher func(string) bool
+
+type rule struct {
+	apiVersion  matcher
+	resource    matcher
+	name        matcher
+	namespace   matcher
+	selector    labels.Selector
+	annotations map[string]matcher
+	expression  cel.Program
+}
+
+// Compile builds the engine for the supplied filters. Rules that fail to compile
+// are left out of the engine and reported in the returned error. An invalid include
+// rule fails closed: only the composition itself is kept in the tree.
+func Compile(filters watcher.Filters) (*Engine, error) {
+	var errs []error
+	engine := &Engine{}
+	engine.include, errs = compileRules("include", filters.Include, errs)
+	engine.includeInvalid = len(engine.include) < len(filters.Include)
+	engine.exclude, errs = compileRules("exclude", filters.Exclude, errs)
+	return engine, errors.Join(errs...)
+}
+
+func compileRules(kind string, specs []watcher.FilterRule, errs []error) ([]rule, []error) {
+	rules := make([]rule, 0, len(specs))
+	for i, spec := range specs {
+		r, err := compileRule(spec)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s[%d]: %w", kind, i, err))
+			continue
+		}
+		rules = append(rules, r)
+	}
+	return rules, errs
+}
+
+// ValidateRule reports why a filter rule does not compile
+func ValidateRule(spec watcher.FilterRule) error {
+	_, err := compileRule(spec)
+	return err
+}
+
+func compileRule(spec watcher.FilterRule) (rule, error) {
+	var err error
+	r := rule{}
+
+	fields := []struct {
+		name    string
+		pattern string
+		target  *matcher
+	}{
+		{"apiVersion", spec.ApiVersion, &r.apiVersion},
+		{"resource", spec.Resource, &r.resource},
+		{"name", spec.Name, &r.name},
+		{"namespace", spec.Namespace, &r.namespace},
+	}
+	for _, field := range fields {
+		if *field.target, err = compilePattern(spec.MatchType, field.pattern); err != nil {
+			return rule{}, fmt.Errorf("invalid %s pattern: %w", field.name, err)
+		}
+	}
+
+	if spec.LabelSelector != nil {
+		if r.selector, err = metav1.LabelSelectorAsSelector(spec.LabelSelector); err != nil {
+			return rule{}, fmt.Errorf("invalid labelSelector: %w", err)
+		}
+	}
+
+	if len(spec.Annotations) > 0 {
+		r.annotations = make(map[string]matcher, len(spec.Annotations))
+		for key, pattern := range spec.Annotations {
+			if r.annotations[key], err = compilePattern(spec.MatchType, pattern); err != nil {
+				return rule{}, fmt.Errorf("invalid pattern for annotation %s: %w", key, err)
+			}
+		}
+	}
+
+	if spec.Expression != "" {
+		if r.expression, err = compileExpression(spec.Expression); err != nil {
+			return rule{}, err
+		}
+	}
+	return r, nil
+}
+
+// compilePattern turns a pattern into a matcher according to the match type,
+// an empty pattern always matches
+func compilePattern(matchType watcher.MatchType, pattern string) (matcher, error) {
+	if pattern == "" {
+		return func(string) bool { return true }, nil
+	}
+
+	switch matchType {
+	case watcher.MatchTypeExact:
+		return func(value string) bool { return value == pattern }, nil
+	case watcher.MatchTypeSubstring:
+		return func(value string) bool { return strings.Contains(value, pattern) }, nil
+	case watcher.MatchTypeGlob:
+		regex, err := regexp.Compile(globToRegex(pattern))
+		if err != nil {
+			return nil, err
+		}
+		return regex.MatchString, nil
+	case watcher.MatchTypeRegex:
+		regex, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", pattern))
+		if err != nil {
+			return nil, err
+		}
+		return regex.MatchString, nil
+	case "":
+		// Kept for backward compatibility: exact match or unanchored regex
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return func(value string) bool { return value == pattern || regex.MatchString(value) }, nil
+	default:
+		return nil, fmt.Errorf("unknown matchType %q", matchType)
+	}
+}
+
+// globToRegex converts a glob, supporting "*" and "?", to an anchored regex
+func globToRegex(glob string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, c := range glob {
+		switch c {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// IsExcludedByReference reports whether a resource is excluded by a rule that does not
+// need the resource object, so that it can be skipped before fetching it
+func (e *Engine) IsExcludedByReference(resource watcher.Reference) bool {
+	for _, exclude := range e.exclude {
+		if exclude.matches(resource, nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFiltered reports whether a resource must be left out of the resource tree.
+// Include rules only apply to managed resources, never to the composition itself,
+// while exclude rules apply to both and always win over include rules.
+func (e *Engine) IsFiltered(resource watcher.Reference, obj *unstructured.Unstructured, isComposition bool) bool {
+	if !isComposition && e.includeInvalid {
+		return true
+	}
+	if !isComposition && len(e.include) > 0 {
+		included := false
+		for _, include := range e.include {
+			if include.matches(resource, obj) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return true
+		}
+	}
+
+	for _, exclude := range e.exclude {
+		if exclude.matches(resource, obj) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the rule matches the resource. Rules on labels, annotations
+// and expressions never match when the fetched object is not available.
+func (r rule) matches(managedResource watcher.Reference, obj *unstructured.Unstructured) bool {
+	namespace := managedResource.Namespace
+	if obj != nil {
+		namespace = obj.GetNamespace()
+	}
+
+	if !r.apiVersion(managedResource.ApiVersion) ||
+		!r.resource(managedResource.Resource) ||
+		!r.name(managedResource.Name) ||
+		!r.namespace(namespace) {
+		return false
+	}
+
+	if r.selector == nil && len(r.annotations) == 0 && r.expression == nil {
+		return true
+	}
+	if obj == nil {
+		return false
+	}
+
+	if r.selector != nil && !r.selector.Matches(labels.Set(obj.GetLabels())) {
+		return false
+	}
+
+	annotations := obj.GetAnnotations()
+	for key, match := range r.annotations {
+		value, ok := annotations[key]
+		if !ok || !match(value) {
+			return false
+		}
+	}
+
+	if r.expression != nil {
+		// Evaluation errors, like a missing map key, mean the rule does not match
+		match, err := evalExpression(r.expression, obj.Object)
+		if err != nil || !match {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,235 @@
+package filters
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	watcher "github.com/krateoplatformops/composition-watcher/api/v1"
+)
+
+func TestCompilePattern(t *testing.T) {
+	tests := []struct {
+		name      string
+		matchType watcher.MatchType
+		pattern   string
+		matches   []string
+		misses    []string
+	}{
+		{
+			name:    "empty pattern",
+			pattern: "",
+			matches: []string{"", "anything"},
+		},
+		{
+			name:      "exact",
+			matchType: watcher.MatchTypeExact,
+			pattern:   "app.v1",
+			matches:   []string{"app.v1"},
+			misses:    []string{"appxv1", "my-app.v1", "app.v1-2"},
+		},
+		{
+			name:      "substring",
+			matchType: watcher.MatchTypeSubstring,
+			pattern:   "app",
+			matches:   []string{"app", "my-app-1"},
+			misses:    []string{"ap", "APP"},
+		},
+		{
+			name:      "glob",
+			matchType: watcher.MatchTypeGlob,
+			pattern:   "app-*",
+			matches:   []string{"app-", "app-1", "app-1-2"},
+			misses:    []string{"my-app-1", "app"},
+		},
+		{
+			name:      "glob single character",
+			matchType: watcher.MatchTypeGlob,
+			pattern:   "app-?",
+			matches:   []string{"app-1"},
+			misses:    []string{"app-", "app-12"},
+		},
+		{
+			name:      "glob quotes regex characters",
+			matchType: watcher.MatchTypeGlob,
+			pattern:   "a.b+",
+			matches:   []string{"a.b+"},
+			misses:    []string{"axb+", "a.bb"},
+		},
+		{
+			name:      "regex is anchored",
+			matchType: watcher.MatchTypeRegex,
+			pattern:   "app|web",
+			matches:   []string{"app", "web"},
+			misses:    []string{"my-app", "app-1", "webapp"},
+		},
+		{
+			name:    "legacy default is unanchored",
+			pattern: "app",
+			matches: []string{"app", "my-app-1"},
+			misses:  []string{"ap"},
+		},
+		{
+			name:    "legacy default matches the pattern literally",
+			pattern: "a+b",
+			matches: []string{"a+b", "aab"},
+			misses:  []string{"b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, err := compilePattern(tt.matchType, tt.pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			for _, value := range tt.matches {
+				if !match(value) {
+					t.Errorf("expected %q to match %q", tt.pattern, value)
+				}
+			}
+			for _, value := range tt.misses {
+				if match(value) {
+					t.Errorf("expected %q not to match %q", tt.pattern, value)
+				}
+			}
+		})
+	}
+}
+
+func TestCompilePatternErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		matchType watcher.MatchType
+		pattern   string
+	}{
+		{name: "unknown match type", matchType: "Fuzzy", pattern: "app"},
+		{name: "invalid regex", matchType: watcher.MatchTypeRegex, pattern: "app("},
+		{name: "invalid legacy regex", pattern: "app("},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compilePattern(tt.matchType, tt.pattern); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestCompileKeepsValidRules(t *testing.T) {
+	engine, err := Compile(watcher.Filters{
+		Exclude: []watcher.FilterRule{
+			{Resource: "secrets", MatchType: watcher.MatchTypeExact},
+			{Name: "app(", MatchType: watcher.MatchTypeRegex},
+		},
+	})
+	if err == nil || !strings.Contains(err.Error(), "exclude[1]") {
+		t.Fatalf("expected the error of exclude[1], got %v", err)
+	}
+	if !engine.IsExcludedByReference(watcher.Reference{ApiVersion: "v1", Resource: "secrets", Name: "token"}) {
+		t.Error("expected the valid rule to be kept")
+	}
+}
+
+func TestInvalidIncludeRuleFailsClosed(t *testing.T) {
+	engine, err := Compile(watcher.Filters{
+		Include: []watcher.FilterRule{
+			{Resource: "deployments(", MatchType: watcher.MatchTypeRegex},
+		},
+	})
+	if err == nil {
+		t.Fatal("expected the error of include[0]")
+	}
+
+	composition := watcher.Reference{ApiVersion: "composition.krateo.io/v1", Resource: "fireworksapps", Name: "app", Namespace: "demo"}
+	if !engine.IsFiltered(watcher.Reference{ApiVersion: "apps/v1", Resource: "deployments", Name: "app"}, nil, false) {
+		t.Error("expected the managed resources to be filtered out")
+	}
+	if engine.IsFiltered(composition, nil, true) {
+		t.Error("expected the composition to be kept")
+	}
+}
+
+func TestEngine(t *testing.T) {
+	deployment := watcher.Reference{ApiVersion: "apps/v1", Resource: "deployments", Name: "app", Namespace: "demo"}
+	secret := watcher.Reference{ApiVersion: "v1", Resource: "secrets", Name: "app-token", Namespace: "demo"}
+	composition := watcher.Reference{ApiVersion: "composition.krateo.io/v1", Resource: "fireworksapps", Name: "app", Namespace: "demo"}
+
+	labelled := &unstructured.Unstructured{}
+	labelled.SetNamespace("demo")
+	labelled.SetLabels(map[string]string{"tier": "internal"})
+
+	engine, err := Compile(watcher.Filters{
+		Include: []watcher.FilterRule{
+			{Resource: "deployments", MatchType: watcher.MatchTypeExact},
+			{Resource: "secrets", MatchType: watcher.MatchTypeExact},
+		},
+		Exclude: []watcher.FilterRule{
+			{Name: "*-token", MatchType: watcher.MatchTypeGlob},
+			{LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "internal"}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if engine.IsFiltered(deployment, nil, false) {
+		t.Error("expected an included resource to be kept")
+	}
+	if !engine.IsFiltered(secret, nil, false) {
+		t.Error("expected exclude rules to win over include rules")
+	}
+	if engine.IsFiltered(composition, nil, true) {
+		t.Error("expected include rules not to apply to the composition")
+	}
+	if !engine.IsFiltered(watcher.Reference{ApiVersion: "v1", Resource: "configmaps", Name: "app"}, nil, false) {
+		t.Error("expected a resource matching no include rule to be filtered")
+	}
+
+	if engine.IsExcludedByReference(deployment) {
+		t.Error("expected a label rule not to match without the object")
+	}
+	if !engine.IsFiltered(deployment, labelled, false) {
+		t.Error("expected a label rule to match the object")
+	}
+}
+
+func TestExpressionOnlyRule(t *testing.T) {
+	var filters watcher.Filters
+	err := json.Unmarshal([]byte(`{"exclude": [{"expression": "object.metadata.labels['tier'] == 'internal' || object.kind == 'Secret'"}]}`), &filters)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	engine, err := Compile(filters)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	secretRef := watcher.Reference{ApiVersion: "v1", Resource: "secrets", Name: "token", Namespace: "demo"}
+	secret := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]interface{}{"name": "token", "namespace": "demo", "labels": map[string]interface{}{}},
+	}}
+	deploymentRef := watcher.Reference{ApiVersion: "apps/v1", Resource: "deployments", Name: "app", Namespace: "demo"}
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "app", "namespace": "demo", "labels": map[string]interface{}{"tier": "web"}},
+	}}
+
+	if engine.IsExcludedByReference(secretRef) {
+		t.Error("expected an expression not to match without the object")
+	}
+	if !engine.IsFiltered(secretRef, secret, false) {
+		t.Error("expected the expression to match the secret")
+	}
+	if engine.IsFiltered(deploymentRef, deployment, false) {
+		t.Error("expected the expression not to match the deployment")
+	}
+}
@@ -0,0 +1,254 @@
+package health
+
+import (
+	"fmt"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Crossplane and Krateo reasons for a Ready condition that is not True yet
+var progressingReasons = []string{"Creating", "Deleting", "Progressing", "Pending"}
+
+type condition struct {
+	Type    string
+	Status  string
+	Reason  string
+	Message string
+}
+
+func getConditions(obj *unstructured.Unstructured) []condition {
+	list, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	res := make([]condition, 0, len(list))
+	for _, item := range list {
+		c, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		cond := condition{}
+		cond.Type, _ = c["type"].(string)
+		cond.Status, _ = c["status"].(string)
+		cond.Reason, _ = c["reason"].(string)
+		cond.Message, _ = c["message"].(string)
+		res = append(res, cond)
+	}
+	return res
+}
+
+func findCondition(conditions []condition, conditionType string) (condition, bool) {
+	for _, c := range conditions {
+		if c.Type == conditionType {
+			return c, true
+		}
+	}
+	return condition{}, false
+}
+
+func fromCondition(status Status, c condition) Result {
+	return Result{Status: status, Type: c.Type, Reason: c.Reason, Message: c.Message}
+}
+
+// conditionsHealth understands the Crossplane/Krateo Ready and Synced conditions,
+// falling back to the Available condition used by many controllers
+func conditionsHealth(obj *unstructured.Unstructured) Result {
+	conditions := getConditions(obj)
+	if len(conditions) == 0 {
+		return Result{Status: Healthy, Message: "resource has no status conditions"}
+	}
+
+	if synced, ok := findCondition(conditions, "Synced"); ok && synced.Status == "False" {
+		return fromCondition(Degraded, synced)
+	}
+
+	ready, ok := findCondition(conditions, "Ready")
+	if !ok {
+		ready, ok = findCondition(conditions, "Available")
+	}
+	if !ok {
+		if synced, ok := findCondition(conditions, "Synced"); ok {
+			return fromCondition(Healthy, synced)
+		}
+		return Result{Status: Unknown, Message: "no Ready, Available or Synced condition found"}
+	}
+
+	switch ready.Status {
+	case "True":
+		return fromCondition(Healthy, ready)
+	case "False":
+		if slices.Contains(progressingReasons, ready.Reason) {
+			return fromCondition(Progressing, ready)
+		}
+		return fromCondition(Degraded, ready)
+	default:
+		return fromCondition(Progressing, ready)
+	}
+}
+
+func nestedInt(obj *unstructured.Unstructured, fields ...string) int64 {
+	value, _, _ := unstructured.NestedInt64(obj.Object, fields...)
+	return value
+}
+
+func nestedString(obj *unstructured.Unstructured, fields ...string) string {
+	value, _, _ := unstructured.NestedString(obj.Object, fields...)
+	return value
+}
+
+// specReplicas returns spec.replicas, which defaults to 1 when omitted
+func specReplicas(obj *unstructured.Unstructured) int64 {
+	replicas, found, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	if !found {
+		return 1
+	}
+	return replicas
+}
+
+// isGenerationObserved reports whether the controller has seen the latest spec
+func isGenerationObserved(obj *unstructured.Unstructured) bool {
+	return nestedInt(obj, "status", "observedGeneration") >= obj.GetGeneration()
+}
+
+func deploymentHealth(obj *unstructured.Unstructured) Result {
+	conditions := getConditions(obj)
+	if progressing, ok := findCondition(conditions, "Progressing"); ok && progressing.Reason == "ProgressDeadlineExceeded" {
+		return fromCondition(Degraded, progressing)
+	}
+	if !isGenerationObserved(obj) {
+		return Result{Status: Progressing, Type: "ObservedGeneration", Message: "waiting for the deployment spec update to be observed"}
+	}
+
+	replicas := specReplicas(obj)
+	updated := nestedInt(obj, "status", "updatedReplicas")
+	available := nestedInt(obj, "status", "availableReplicas")
+	switch {
+	case updated < replicas:
+		return Result{Status: Progressing, Type: "Replicas", Message: fmt.Sprintf("%d of %d replicas updated", updated, replicas)}
+	case nestedInt(obj, "status", "replicas") > updated:
+		return Result{Status: Progressing, Type: "Replicas", Message: "old replicas are pending termination"}
+	case available < replicas:
+		return Result{Status: Progressing, Type: "Replicas", Message: fmt.Sprintf("%d of %d replicas available", available, replicas)}
+	}
+	return Result{Status: Healthy, Type: "Replicas", Message: fmt.Sprintf("%d of %d replicas available", available, replicas)}
+}
+
+func statefulSetHealth(obj *unstructured.Unstructured) Result {
+	if !isGenerationObserved(obj) {
+		return Result{Status: Progressing, Type: "ObservedGeneration", Message: "waiting for the statefulset spec update to be observed"}
+	}
+
+	replicas := specReplicas(obj)
+	ready := nestedInt(obj, "status", "readyReplicas")
+	if ready < replicas {
+		return Result{Status: Progressing, Type: "Replicas", Message: fmt.Sprintf("%d of %d replicas ready", ready, replicas)}
+	}
+	if current, update := nestedString(obj, "status", "currentRevision"), nestedString(obj, "status", "updateRevision"); update != "" && current != update {
+		return Result{Status: Progressing, Type: "Revision", Message: fmt.Sprintf("rolling out revision %s", update)}
+	}
+	return Result{Status: Healthy, Type: "Replicas", Message: fmt.Sprintf("%d of %d replicas ready", ready, replicas)}
+}
+
+func daemonSetHealth(obj *unstructured.Unstructured) Result {
+	if !isGenerationObserved(obj) {
+		return Result{Status: Progressing, Type: "ObservedGeneration", Message: "waiting for the daemonset spec update to be observed"}
+	}
+
+	desired := nestedInt(obj, "status", "desiredNumberScheduled")
+	updated := nestedInt(obj, "status", "updatedNumberScheduled")
+	ready := nestedInt(obj, "status", "numberReady")
+	if updated < desired {
+		return Result{Status: Progressing, Type: "Pods", Message: fmt.Sprintf("%d of %d pods updated", updated, desired)}
+	}
+	if ready < desired {
+		return Result{Status: Progressing, Type: "Pods", Message: fmt.Sprintf("%d of %d pods ready", ready, desired)}
+	}
+	return Result{Status: Healthy, Type: "Pods", Message: fmt.Sprintf("%d of %d pods ready", ready, desired)}
+}
+
+func replicaSetHealth(obj *unstructured.Unstructured) Result {
+	if failure, ok := findCondition(getConditions(obj), "ReplicaFailure"); ok && failure.Status == "True" {
+		return fromCondition(Degraded, failure)
+	}
+
+	replicas := specReplicas(obj)
+	ready := nestedInt(obj, "status", "readyReplicas")
+	if ready < replicas {
+		return Result{Status: Progressing, Type: "Replicas", Message: fmt.Sprintf("%d of %d replicas ready", ready, replicas)}
+	}
+	return Result{Status: Healthy, Type: "Replicas", Message: fmt.Sprintf("%d of %d replicas ready", ready, replicas)}
+}
+
+// Reasons of a waiting container that will not recover on their own
+var degradedWaitingReasons = []string{"CrashLoopBackOff", "ImagePullBackOff", "ErrImagePull", "CreateContainerConfigError", "InvalidImageName"}
+
+func podHealth(obj *unstructured.Unstructured) Result {
+	statuses, _, _ := unstructured.NestedSlice(obj.Object, "status", "containerStatuses")
+	for _, item := range statuses {
+		containerStatus, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		reason, _, _ := unstructured.NestedString(containerStatus, "state", "waiting", "reason")
+		if slices.Contains(degradedWaitingReasons, reason) {
+			message, _, _ := unstructured.NestedString(containerStatus, "state", "waiting", "message")
+			return Result{Status: Degraded, Type: "ContainerStatus", Reason: reason, Message: message}
+		}
+	}
+
+	phase := nestedString(obj, "status", "phase")
+	result := Result{Type: "Phase", Reason: phase, Message: nestedString(obj, "status", "message")}
+	switch phase {
+	case "Succeeded":
+		result.Status = Healthy
+	case "Failed":
+		result.Status = Degraded
+	case "Pending":
+		result.Status = Progressing
+	case "Running":
+		if ready, ok := findCondition(getConditions(obj), "Ready"); ok && ready.Status != "True" {
+			return fromCondition(Progressing, ready)
+		}
+		result.Status = Healthy
+	default:
+		result.Status = Unknown
+	}
+	return result
+}
+
+func jobHealth(obj *unstructured.Unstructured) Result {
+	conditions := getConditions(obj)
+	if failed, ok := findCondition(conditions, "Failed"); ok && failed.Status == "True" {
+		return fromCondition(Degraded, failed)
+	}
+	if complete, ok := findCondition(conditions, "Complete"); ok && complete.Status == "True" {
+		return fromCondition(Healthy, complete)
+	}
+	return Result{Status: Progressing, Type: "Complete", Message: fmt.Sprintf("%d pods active", nestedInt(obj, "status", "active"))}
+}
+
+func pvcHealth(obj *unstructured.Unstructured) Result {
+	phase := nestedString(obj, "status", "phase")
+	result := Result{Type: "Phase", Reason: phase}
+	switch phase {
+	case "Bound":
+		result.Status = Healthy
+	case "Pending":
+		result.Status = Progressing
+	case "Lost":
+		result.Status = Degraded
+	default:
+		result.Status = Unknown
+	}
+	return result
+}
+
+func serviceHealth(obj *unstructured.Unstructured) Result {
+	if nestedString(obj, "spec", "type") != "LoadBalancer" {
+		return Result{Status: Healthy, Type: "Type", Reason: nestedString(obj, "spec", "type")}
+	}
+
+	ingress, _, _ := unstructured.NestedSlice(obj.Object, "status", "loadBalancer", "ingress")
+	if len(ingress) == 0 {
+		return Result{Status: Progressing, Type: "LoadBalancer", Message: "waiting for the load balancer ingress"}
+	}
+	return Result{Status: Healthy, Type: "LoadBalancer"}
+}
@@ -0,0 +1,87 @@
+package health
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Status is the normalized health of a resource
+type Status string
+
+const (
+	Healthy     Status = "Healthy"
+	Progressing Status = "Progressing"
+	Degraded    Status = "Degraded"
+	Missing     Status = "Missing"
+	Unknown     Status = "Unknown"
+)
+
+// Result is the outcome of a health assessment. Type is the condition or field
+// the assessment is based on.
+type Result struct {
+	Status  Status
+	Type    string
+	Reason  string
+	Message string
+}
+
+// Checker assesses the health of a resource
+type Checker func(obj *unstructured.Unstructured) Result
+
+// Registry holds the health checkers keyed by group and kind. Scripted checkers,
+// loaded from user-defined rules, take precedence over the registered ones. Resources
+// without any checker are assessed through their status conditions.
+type Registry struct {
+	mu       sync.RWMutex
+	checkers map[schema.GroupKind]Checker
+	scripted map[schema.GroupKind]Checker
+}
+
+// NewRegistry returns a registry with the built-in checkers for the core kinds
+func NewRegistry() *Registry {
+	r := &Registry{
+		checkers: make(map[schema.GroupKind]Checker),
+	}
+	r.Register(schema.GroupKind{Group: "apps", Kind: "Deployment"}, deploymentHealth)
+	r.Register(schema.GroupKind{Group: "apps", Kind: "StatefulSet"}, statefulSetHealth)
+	r.Register(schema.GroupKind{Group: "apps", Kind: "DaemonSet"}, daemonSetHealth)
+	r.Register(schema.GroupKind{Group: "apps", Kind: "ReplicaSet"}, replicaSetHealth)
+	r.Register(schema.GroupKind{Group: "", Kind: "Pod"}, podHealth)
+	r.Register(schema.GroupKind{Group: "batch", Kind: "Job"}, jobHealth)
+	r.Register(schema.GroupKind{Group: "", Kind: "PersistentVolumeClaim"}, pvcHealth)
+	r.Register(schema.GroupKind{Group: "", Kind: "Service"}, serviceHealth)
+	return r
+}
+
+// Register sets the checker of a group and kind, replacing any existing one
+func (r *Registry) Register(gk schema.GroupKind, checker Checker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checkers[gk] = checker
+}
+
+// SetScripted replaces all the scripted checkers
+func (r *Registry) SetScripted(checkers map[schema.GroupKind]Checker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scripted = checkers
+}
+
+// Assess returns the health of the resource
+func (r *Registry) Assess(obj *unstructured.Unstructured) Result {
+	gk := obj.GroupVersionKind().GroupKind()
+
+	r.mu.RLock()
+	checker, ok := r.scripted[gk]
+	if !ok {
+		checker, ok = r.checkers[gk]
+	}
+	r.mu.RUnlock()
+
+	if !ok {
+		checker = conditionsHealth
+	}
+	return checker(obj)
+}
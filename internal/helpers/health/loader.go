@@ -0,0 +1,95 @@
+package health
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+var configMapsGVR = schema.GroupVersionResource{Group: "", Version: "v1", Resource: "configmaps"}
+
+// ScriptLoader watches a ConfigMap of health rules and hot-reloads them into the registry.
+// Each key of the ConfigMap is a "Kind.group" and each value a CEL health rule.
+type ScriptLoader struct {
+	dynClient dynamic.Interface
+	configMap types.NamespacedName
+	registry  *Registry
+	logger    logging.Logger
+}
+
+func NewScriptLoader(dynClient dynamic.Interface, configMap types.NamespacedName, registry *Registry, logger logging.Logger) *ScriptLoader {
+	return &ScriptLoader{
+		dynClient: dynClient,
+		configMap: configMap,
+		registry:  registry,
+		logger:    logger,
+	}
+}
+
+// NeedLeaderElection is false since every replica needs the health rules
+func (l *ScriptLoader) NeedLeaderElection() bool {
+	return false
+}
+
+// Start watches the ConfigMap until the context is cancelled
+func (l *ScriptLoader) Start(ctx context.Context) error {
+	fac := dynamicinformer.NewFilteredDynamicSharedInformerFactory(l.dynClient, 0, l.configMap.Namespace, func(opts *metav1.ListOptions) {
+		opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", l.configMap.Name).String()
+	})
+	informer := fac.ForResource(configMapsGVR).Informer()
+
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			l.load(obj)
+		},
+		UpdateFunc: func(_ interface{}, newObj interface{}) {
+			l.load(newObj)
+		},
+		DeleteFunc: func(_ interface{}) {
+			l.registry.SetScripted(nil)
+			l.logger.Info("Health rules configmap deleted, rules removed", "configmap", l.configMap.String())
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to add health rules event handler: %w", err)
+	}
+
+	informer.Run(ctx.Done())
+	return nil
+}
+
+func (l *ScriptLoader) load(obj interface{}) {
+	item, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	data, _, err := unstructured.NestedStringMap(item.Object, "data")
+	if err != nil {
+		l.logger.Info(fmt.Sprintf("unable to read health rules: %s", err), "configmap", l.configMap.String())
+		return
+	}
+
+	checkers := make(map[schema.GroupKind]Checker, len(data))
+	for key, script := range data {
+		checker, err := CompileScript(script)
+		if err != nil {
+			// The error is reported on every node of this kind instead of breaking the tree
+			l.logger.Info(fmt.Sprintf("invalid health rule: %s", err), "configmap", l.configMap.String(), "key", key)
+			checker = scriptError(err)
+		}
+		checkers[ParseRuleKey(key)] = checker
+	}
+
+	l.registry.SetScripted(checkers)
+	l.logger.Info("Health rules loaded", "configmap", l.configMap.String(), "rules", len(checkers))
+}
@@ -0,0 +1,94 @@
+package health
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// severity orders the statuses from the best to the worst
+var severity = map[Status]int{
+	Healthy:     0,
+	Progressing: 1,
+	Unknown:     2,
+	Missing:     3,
+	Degraded:    4,
+}
+
+// Worst returns the worst of two statuses
+func Worst(a, b Status) Status {
+	if severity[b] > severity[a] {
+		return b
+	}
+	return a
+}
+
+// Rollup aggregates the health of the resources of one or more trees. The status
+// is the worst among the resources, the score is the weighted percentage of healthy
+// resources. Kinds with weight 0 are left out, kinds without a weight count once.
+type Rollup struct {
+	weights map[schema.GroupKind]int
+
+	status    Status
+	total     int
+	healthy   int
+	unhealthy int
+}
+
+func NewRollup(weights map[schema.GroupKind]int) *Rollup {
+	return &Rollup{
+		weights: weights,
+		status:  Healthy,
+	}
+}
+
+// Add accounts for a resource of the given kind
+func (r *Rollup) Add(gk schema.GroupKind, status Status) {
+	weight, ok := r.weights[gk]
+	if !ok {
+		weight = 1
+	}
+	if weight == 0 {
+		return
+	}
+
+	r.status = Worst(r.status, status)
+	r.total += weight
+	if status == Healthy {
+		r.healthy += weight
+	} else {
+		r.unhealthy++
+	}
+}
+
+// Merge accounts for the resources of another rollup, built with the same weights
+func (r *Rollup) Merge(other *Rollup) {
+	if other == nil {
+		return
+	}
+	r.status = Worst(r.status, other.status)
+	r.total += other.total
+	r.healthy += other.healthy
+	r.unhealthy += other.unhealthy
+}
+
+// Status returns the worst status, an empty rollup is healthy
+func (r *Rollup) Status() Status {
+	return r.status
+}
+
+// Score returns the weighted percentage of healthy resources
+func (r *Rollup) Score() int {
+	if r.total == 0 {
+		return 100
+	}
+	return r.healthy * 100 / r.total
+}
+
+// Message describes how many resources are not healthy
+func (r *Rollup) Message() string {
+	if r.unhealthy == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d resources are not healthy, the worst is %s", r.unhealthy, r.status)
+}
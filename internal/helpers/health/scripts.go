@@ -0,0 +1,74 @@
+package health
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var validStatuses = []Status{Healthy, Progressing, Degraded, Missing, Unknown}
+
+// ParseRuleKey parses a health rule key in the form "Kind.group", or "Kind" for the core group.
+// ConfigMap keys cannot contain slashes, hence the kubectl-like notation.
+func ParseRuleKey(key string) schema.GroupKind {
+	kind, group, _ := strings.Cut(key, ".")
+	return schema.GroupKind{Group: group, Kind: kind}
+}
+
+// CompileScript compiles a CEL health rule. The expression is evaluated against the
+// resource, available as "object", and must return a map with a "status" and a "message".
+func CompileScript(script string) (Checker, error) {
+	env, err := cel.NewEnv(cel.Variable("object", cel.MapType(cel.StringType, cel.DynType)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create CEL environment: %w", err)
+	}
+
+	ast, iss := env.Compile(script)
+	if iss.Err() != nil {
+		return nil, fmt.Errorf("unable to compile health rule: %w", iss.Err())
+	}
+
+	prg, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build program for health rule: %w", err)
+	}
+
+	return func(obj *unstructured.Unstructured) Result {
+		result, err := evalScript(prg, obj)
+		if err != nil {
+			return Result{Status: Unknown, Type: "HealthRule", Message: err.Error()}
+		}
+		return result
+	}, nil
+}
+
+// scriptError returns a checker that reports a health rule that could not be compiled
+func scriptError(err error) Checker {
+	return func(*unstructured.Unstructured) Result {
+		return Result{Status: Unknown, Type: "HealthRule", Message: err.Error()}
+	}
+}
+
+func evalScript(prg cel.Program, obj *unstructured.Unstructured) (Result, error) {
+	out, _, err := prg.Eval(map[string]interface{}{"object": obj.Object})
+	if err != nil {
+		return Result{}, fmt.Errorf("health rule evaluation failed: %w", err)
+	}
+
+	native, err := out.ConvertToNative(reflect.TypeOf(map[string]string{}))
+	if err != nil {
+		return Result{}, fmt.Errorf("health rule must return a map of strings: %w", err)
+	}
+	values := native.(map[string]string)
+
+	status := Status(values["status"])
+	if !slices.Contains(validStatuses, status) {
+		return Result{}, fmt.Errorf("health rule returned invalid status %q", values["status"])
+	}
+	return Result{Status: status, Type: "HealthRule", Reason: values["reason"], Message: values["message"]}, nil
+}
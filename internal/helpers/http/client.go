@@ -5,6 +5,10 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
+
+	"github.com/krateoplatformops/composition-watcher/internal/helpers/metrics"
 )
 
 func Request(method string, path string, data []byte) error {
@@ -15,41 +19,43 @@ func Request(method string, path string, data []byte) error {
 
 	switch method {
 	case "POST":
-		return post(fmt.Sprintf("%s%s", serviceUrl, path), data)
+		_, err := post(fmt.Sprintf("%s%s", serviceUrl, path), data)
+		return err
 	case "DELETE":
-		return delete(fmt.Sprintf("%s%s", serviceUrl, path))
+		return deleteRequest(fmt.Sprintf("%s%s", serviceUrl, path))
 	default:
 		return fmt.Errorf("method not allowed")
 	}
 }
 
-func post(url string, data []byte) error {
+// post returns the response headers, which advertise the capabilities of the webservice
+func post(url string, data []byte) (http.Header, error) {
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
 	if err != nil {
-		return fmt.Errorf("could not create http POST request: %w", err)
+		return nil, fmt.Errorf("could not create http POST request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := do(req, len(data))
 	if err != nil {
-		return fmt.Errorf("could not send http POST form: %w", err)
+		return nil, fmt.Errorf("could not send http POST form: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("received error from webservice: %s", resp.Status)
+		return nil, fmt.Errorf("received error from webservice: %s", resp.Status)
 	}
 
-	return nil
+	return resp.Header, nil
 }
 
-func delete(url string) error {
+func deleteRequest(url string) error {
 	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
 		return fmt.Errorf("could not create http DELETE request: %w", err)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := do(req, 0)
 	if err != nil {
 		return fmt.Errorf("could not send http DELETE: %w", err)
 	}
@@ -60,3 +66,20 @@ func delete(url string) error {
 	}
 	return nil
 }
+
+// do sends a request to the webservice, recording its latency, status code and payload size
+func do(req *http.Request, size int) (*http.Response, error) {
+	if size > 0 {
+		metrics.PayloadSize.WithLabelValues(req.Method).Observe(float64(size))
+	}
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	metrics.HandlerRequestDuration.WithLabelValues(req.Method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.HandlerRequests.WithLabelValues(req.Method, "error").Inc()
+		return nil, err
+	}
+	metrics.HandlerRequests.WithLabelValues(req.Method, strconv.Itoa(resp.StatusCode)).Inc()
+	return resp, nil
+}
@@ -0,0 +1,45 @@
+package http
+
+import (
+	"sync"
+	"time"
+)
+
+// RequestError is a failed request to the webservice
+type RequestError struct {
+	Time   time.Time
+	Method string
+	Error  string
+}
+
+// requestErrors keeps the last failed request of every composition. It is
+// cleared when the tree of the composition is deleted from the webservice.
+var requestErrors = struct {
+	mu   sync.Mutex
+	last map[string]RequestError
+}{
+	last: make(map[string]RequestError),
+}
+
+// LastError returns the last failed request to the webservice for a composition
+func LastError(uid string) (RequestError, bool) {
+	requestErrors.mu.Lock()
+	defer requestErrors.mu.Unlock()
+	res, ok := requestErrors.last[uid]
+	return res, ok
+}
+
+func recordError(uid string, method string, err error) {
+	if err == nil {
+		return
+	}
+	requestErrors.mu.Lock()
+	defer requestErrors.mu.Unlock()
+	requestErrors.last[uid] = RequestError{Time: time.Now(), Method: method, Error: err.Error()}
+}
+
+func forgetErrors(uid string) {
+	requestErrors.mu.Lock()
+	defer requestErrors.mu.Unlock()
+	delete(requestErrors.last, uid)
+}
@@ -0,0 +1,213 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"gomodules.xyz/jsonpatch/v2"
+)
+
+const jsonPatchContentType = "application/json-patch+json"
+
+// basePath is the JSON pointer of the build time of a tree, tested by every delta so that
+// the webservice rejects the deltas computed against a tree it does not hold
+const basePath = "/resources/metadata/creationTimestamp"
+
+// errPatchRejected means the handler could not apply a delta and the full tree must be sent
+var errPatchRejected = errors.New("patch rejected by webservice")
+
+// trees keeps the last tree sent for every composition, so that the following
+// ones can be sent as RFC 6902 JSON Patch deltas
+var trees = struct {
+	mu             sync.Mutex
+	last           map[string][]byte
+	patchSupported bool
+}{
+	last: make(map[string][]byte),
+}
+
+// sends serializes the requests for the same composition, the reconciler and the
+// informer workers would otherwise compute their deltas against the same last tree
+var sends = &uidLocks{locks: make(map[string]*uidLock)}
+
+// SendTree sends the tree of a composition to the webservice. When the webservice advertises
+// JSON Patch support through the Accept-Patch header, only the delta from the last tree sent is
+// PATCHed. The full tree is POSTed on the first sync and whenever the patch is rejected.
+func SendTree(uid string, data []byte) error {
+	unlock := sends.lock(uid)
+	defer unlock()
+
+	trees.mu.Lock()
+	last, ok := trees.last[uid]
+	patchSupported := trees.patchSupported
+	trees.mu.Unlock()
+
+	if ok && patchSupported {
+		err := patchTree(uid, last, data)
+		if err == nil {
+			rememberTree(uid, data)
+			return nil
+		}
+		recordError(uid, "PATCH", err)
+		if !errors.Is(err, errPatchRejected) {
+			forgetTree(uid)
+			return err
+		}
+	}
+
+	serviceUrl := os.Getenv("RESOURCE_TREE_HANDLER_URL")
+	if serviceUrl == "" {
+		err := fmt.Errorf("no target webservice found")
+		recordError(uid, "POST", err)
+		return err
+	}
+
+	header, err := post(fmt.Sprintf("%s/compositions/%s", serviceUrl, uid), data)
+	if err != nil {
+		recordError(uid, "POST", err)
+		forgetTree(uid)
+		return err
+	}
+
+	trees.mu.Lock()
+	trees.patchSupported = acceptsJSONPatch(header)
+	trees.mu.Unlock()
+	rememberTree(uid, data)
+	return nil
+}
+
+// DeleteTree deletes the tree of a composition from the webservice
+func DeleteTree(uid string) error {
+	unlock := sends.lock(uid)
+	defer unlock()
+
+	forgetTree(uid)
+	err := Request("DELETE", fmt.Sprintf("/compositions/%s", uid), nil)
+	if err != nil {
+		recordError(uid, "DELETE", err)
+		return err
+	}
+	forgetErrors(uid)
+	return nil
+}
+
+func patchTree(uid string, last []byte, data []byte) error {
+	operations, err := jsonpatch.CreatePatch(last, data)
+	if err != nil {
+		return fmt.Errorf("could not create tree delta: %w", err)
+	}
+	base, err := baseOperation(last)
+	if err != nil {
+		return fmt.Errorf("could not create tree delta: %w", err)
+	}
+	patch, err := json.Marshal(append([]jsonpatch.Operation{base}, operations...))
+	if err != nil {
+		return fmt.Errorf("could not marshal tree delta: %w", err)
+	}
+
+	serviceUrl := os.Getenv("RESOURCE_TREE_HANDLER_URL")
+	if serviceUrl == "" {
+		return fmt.Errorf("no target webservice found")
+	}
+
+	req, err := http.NewRequest("PATCH", fmt.Sprintf("%s/compositions/%s", serviceUrl, uid), bytes.NewBuffer(patch))
+	if err != nil {
+		return fmt.Errorf("could not create http PATCH request: %w", err)
+	}
+	req.Header.Set("Content-Type", jsonPatchContentType)
+
+	resp, err := do(req, len(patch))
+	if err != nil {
+		return fmt.Errorf("could not send http PATCH: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusConflict, http.StatusNotFound, http.StatusPreconditionFailed, http.StatusUnprocessableEntity, http.StatusUnsupportedMediaType, http.StatusMethodNotAllowed:
+		return fmt.Errorf("%w: %s", errPatchRejected, resp.Status)
+	default:
+		return fmt.Errorf("received error from webservice: %s", resp.Status)
+	}
+}
+
+// baseOperation tests the build time of the last tree sent, a failed test makes the webservice
+// reject the whole patch and the full tree is sent again
+func baseOperation(last []byte) (jsonpatch.Operation, error) {
+	var tree struct {
+		Resources struct {
+			Metadata struct {
+				CreationTimestamp interface{} `json:"creationTimestamp"`
+			} `json:"metadata"`
+		} `json:"resources"`
+	}
+	if err := json.Unmarshal(last, &tree); err != nil {
+		return jsonpatch.Operation{}, err
+	}
+	return jsonpatch.NewOperation("test", basePath, tree.Resources.Metadata.CreationTimestamp), nil
+}
+
+func acceptsJSONPatch(header http.Header) bool {
+	for _, value := range header.Values("Accept-Patch") {
+		for _, mediaType := range strings.Split(value, ",") {
+			if strings.TrimSpace(strings.Split(mediaType, ";")[0]) == jsonPatchContentType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func rememberTree(uid string, data []byte) {
+	trees.mu.Lock()
+	defer trees.mu.Unlock()
+	trees.last[uid] = data
+}
+
+func forgetTree(uid string) {
+	trees.mu.Lock()
+	defer trees.mu.Unlock()
+	delete(trees.last, uid)
+}
+
+// uidLocks holds a mutex for every composition with a request in flight
+type uidLocks struct {
+	mu    sync.Mutex
+	locks map[string]*uidLock
+}
+
+type uidLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks the mutex of a composition and returns the function that unlocks it
+func (l *uidLocks) lock(uid string) func() {
+	l.mu.Lock()
+	lock, ok := l.locks[uid]
+	if !ok {
+		lock = &uidLock{}
+		l.locks[uid] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, uid)
+		}
+	}
+}
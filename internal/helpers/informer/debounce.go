@@ -0,0 +1,126 @@
+package watcher
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// debouncer coalesces the events of a composition. The first event fires at once (leading
+// edge) and opens a window: events within the window are held back and fire once the window
+// has elapsed without further events (trailing edge). A composition that never stops changing
+// still fires once the first held back event is maxWait old.
+type debouncer struct {
+	window  time.Duration
+	maxWait time.Duration
+	fire    func(uid types.UID)
+	clock   debounceClock
+
+	mu      sync.Mutex
+	entries map[types.UID]*debounceEntry
+}
+
+type debounceEntry struct {
+	timer        debounceTimer
+	pending      bool
+	firstPending time.Time
+}
+
+func newDebouncer(window time.Duration, maxWait time.Duration, fire func(uid types.UID)) *debouncer {
+	return &debouncer{
+		window:  window,
+		maxWait: maxWait,
+		fire:    fire,
+		clock:   realClock{},
+		entries: make(map[types.UID]*debounceEntry),
+	}
+}
+
+// debounceTimer is the subset of *time.Timer used by the debouncer
+type debounceTimer interface {
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+// debounceClock is the time source of the debouncer, it is replaced in tests
+type debounceClock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) debounceTimer
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) debounceTimer {
+	return time.AfterFunc(d, f)
+}
+
+// Trigger records an event of the composition, a zero window fires every event
+func (d *debouncer) Trigger(uid types.UID) {
+	if d.window <= 0 {
+		d.fire(uid)
+		return
+	}
+
+	d.mu.Lock()
+	entry, ok := d.entries[uid]
+	if !ok {
+		d.entries[uid] = &debounceEntry{
+			timer: d.clock.AfterFunc(d.window, func() { d.expire(uid) }),
+		}
+		d.mu.Unlock()
+		d.fire(uid)
+		return
+	}
+	defer d.mu.Unlock()
+
+	now := d.clock.Now()
+	if !entry.pending {
+		entry.pending = true
+		entry.firstPending = now
+	}
+
+	delay := d.window
+	if d.maxWait > 0 {
+		if remaining := d.maxWait - now.Sub(entry.firstPending); remaining < delay {
+			delay = max(remaining, 0)
+		}
+	}
+	entry.timer.Reset(delay)
+}
+
+// expire closes the window of a composition, firing the events held back
+func (d *debouncer) expire(uid types.UID) {
+	d.mu.Lock()
+	entry, ok := d.entries[uid]
+	if !ok {
+		d.mu.Unlock()
+		return
+	}
+	if !entry.pending {
+		delete(d.entries, uid)
+		d.mu.Unlock()
+		return
+	}
+
+	// The trailing edge opens a new window
+	entry.pending = false
+	entry.timer.Reset(d.window)
+	d.mu.Unlock()
+
+	d.fire(uid)
+}
+
+// Forget drops the window and the events held back of a composition
+func (d *debouncer) Forget(uid types.UID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if entry, ok := d.entries[uid]; ok {
+		entry.timer.Stop()
+		delete(d.entries, uid)
+	}
+}
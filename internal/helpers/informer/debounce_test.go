@@ -0,0 +1,232 @@
+package watcher
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const testUID = types.UID("uid")
+
+// fakeClock is a manual clock, timers fire synchronously in Step in the order they are due
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock  *fakeClock
+	at     time.Time
+	f      func()
+	active bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) debounceTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f, active: true}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Step moves the clock forward, firing the timers that become due on the way
+func (c *fakeClock) Step(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+	for {
+		var next *fakeTimer
+		for _, t := range c.timers {
+			if t.active && !t.at.After(target) && (next == nil || t.at.Before(next.at)) {
+				next = t
+			}
+		}
+		if next == nil {
+			break
+		}
+		next.active = false
+		c.now = next.at
+		c.mu.Unlock()
+		next.f()
+		c.mu.Lock()
+	}
+	c.now = target
+	c.mu.Unlock()
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	wasActive := t.active
+	t.at = t.clock.now.Add(d)
+	t.active = true
+	return wasActive
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	wasActive := t.active
+	t.active = false
+	return wasActive
+}
+
+// fireRecorder records the times, on the fake clock, at which the debouncer fires
+type fireRecorder struct {
+	clock *fakeClock
+	fires []time.Time
+}
+
+func (f *fireRecorder) fire(_ types.UID) {
+	f.fires = append(f.fires, f.clock.Now())
+}
+
+func newTestDebouncer(window time.Duration, maxWait time.Duration) (*debouncer, *fakeClock, *fireRecorder) {
+	clock := newFakeClock()
+	rec := &fireRecorder{clock: clock}
+	d := newDebouncer(window, maxWait, rec.fire)
+	d.clock = clock
+	return d, clock, rec
+}
+
+func TestDebouncerZeroWindowFiresEveryEvent(t *testing.T) {
+	d, _, rec := newTestDebouncer(0, 0)
+
+	for i := 0; i < 3; i++ {
+		d.Trigger(testUID)
+	}
+	if got := len(rec.fires); got != 3 {
+		t.Fatalf("expected 3 fires, got %d", got)
+	}
+}
+
+func TestDebouncerLeadingEdge(t *testing.T) {
+	window := 2 * time.Second
+	d, clock, rec := newTestDebouncer(window, 0)
+
+	d.Trigger(testUID)
+	if got := len(rec.fires); got != 1 {
+		t.Fatalf("expected the first event to fire at once, got %d fires", got)
+	}
+
+	// Without further events the window closes without firing again
+	clock.Step(window)
+	if got := len(rec.fires); got != 1 {
+		t.Fatalf("expected no trailing fire, got %d fires", got)
+	}
+	if _, ok := d.entries[testUID]; ok {
+		t.Fatal("expected the window to be dropped once closed")
+	}
+}
+
+func TestDebouncerTrailingEdge(t *testing.T) {
+	window := 2 * time.Second
+	d, clock, rec := newTestDebouncer(window, 0)
+	start := clock.Now()
+
+	d.Trigger(testUID)
+	for i := 0; i < 3; i++ {
+		clock.Step(500 * time.Millisecond)
+		d.Trigger(testUID)
+	}
+	if got := len(rec.fires); got != 1 {
+		t.Fatalf("expected the events within the window to be held back, got %d fires", got)
+	}
+
+	// Every event extends the window
+	clock.Step(window - time.Millisecond)
+	if got := len(rec.fires); got != 1 {
+		t.Fatalf("expected no fire before the window elapsed since the last event, got %d fires", got)
+	}
+	clock.Step(time.Millisecond)
+	if got := len(rec.fires); got != 2 {
+		t.Fatalf("expected the held back events to fire once, got %d fires", got)
+	}
+	if want := start.Add(1500*time.Millisecond + window); !rec.fires[1].Equal(want) {
+		t.Fatalf("expected the trailing fire at %s, got %s", want, rec.fires[1])
+	}
+
+	// The trailing edge opens a new window, which closes without events
+	clock.Step(window)
+	if got := len(rec.fires); got != 2 {
+		t.Fatalf("expected no further fire, got %d fires", got)
+	}
+	if _, ok := d.entries[testUID]; ok {
+		t.Fatal("expected the window to be dropped once closed")
+	}
+}
+
+func TestDebouncerMaxWait(t *testing.T) {
+	window := 2 * time.Second
+	maxWait := 5 * time.Second
+	d, clock, rec := newTestDebouncer(window, maxWait)
+	start := clock.Now()
+
+	// Events keep coming faster than the window, the trailing edge never happens on its own
+	d.Trigger(testUID)
+	for i := 0; i < 12; i++ {
+		clock.Step(time.Second)
+		d.Trigger(testUID)
+	}
+
+	// The first held back event happened at 1s, the event at 6s comes right after the fire and
+	// starts the next maxWait
+	want := []time.Time{start, start.Add(6 * time.Second), start.Add(11 * time.Second)}
+	if len(rec.fires) != len(want) {
+		t.Fatalf("expected %d fires, got %v", len(want), rec.fires)
+	}
+	for i := range want {
+		if !rec.fires[i].Equal(want[i]) {
+			t.Errorf("expected fire %d at %s, got %s", i, want[i], rec.fires[i])
+		}
+	}
+}
+
+func TestDebouncerForget(t *testing.T) {
+	window := 2 * time.Second
+	d, clock, rec := newTestDebouncer(window, 0)
+
+	d.Trigger(testUID)
+	d.Trigger(testUID)
+	d.Forget(testUID)
+
+	clock.Step(2 * window)
+	if got := len(rec.fires); got != 1 {
+		t.Fatalf("expected the held back events to be dropped, got %d fires", got)
+	}
+
+	// A new event opens a new window and fires at once
+	d.Trigger(testUID)
+	if got := len(rec.fires); got != 2 {
+		t.Fatalf("expected a new leading fire, got %d fires", got)
+	}
+}
+
+func TestDebouncerCompositionsAreIndependent(t *testing.T) {
+	window := 2 * time.Second
+	d, clock, rec := newTestDebouncer(window, 0)
+
+	d.Trigger(testUID)
+	d.Trigger(testUID)
+	d.Trigger(types.UID("other"))
+	if got := len(rec.fires); got != 2 {
+		t.Fatalf("expected a leading fire for each composition, got %d fires", got)
+	}
+
+	clock.Step(window)
+	if got := len(rec.fires); got != 3 {
+		t.Fatalf("expected a trailing fire only for the composition with held back events, got %d fires", got)
+	}
+}
@@ -0,0 +1,92 @@
+package watcher
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+
+	statusGetter "github.com/krateoplatformops/composition-watcher/internal/helpers/kube/compositions"
+)
+
+// watchManaged subscribes the composition to the resources in its status.managed and
+// unsubscribes it from those not listed anymore. Events on the managed resources call onChange.
+func (r *Registry) watchManaged(uid types.UID, composition *unstructured.Unstructured, onChange func()) {
+	desired := make(map[ObjectKey]bool)
+	for _, ref := range statusGetter.ManagedReferences(composition) {
+		gv, err := schema.ParseGroupVersion(ref.ApiVersion)
+		if err != nil {
+			continue
+		}
+		key := ObjectKey{GVR: gv.WithResource(ref.Resource), Namespace: ref.Namespace, Name: ref.Name}
+		// Cluster-scoped resources may be listed with a namespace, they are watched without it
+		if key.Namespace != "" && r.isClusterScoped(key.GVR) {
+			key.Namespace = ""
+		}
+		desired[key] = true
+	}
+
+	handler := cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(_ interface{}, isInInitialList bool) {
+			// The initial list is already part of the tree
+			if !isInInitialList {
+				onChange()
+			}
+		},
+		UpdateFunc: func(_ interface{}, _ interface{}) {
+			onChange()
+		},
+		DeleteFunc: func(_ interface{}) {
+			onChange()
+		},
+	}
+
+	// The lock is held while subscribing, so that a composition being deleted does not leak subscriptions
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	watch, ok := r.compositions[uid]
+	if !ok {
+		return
+	}
+	// A composition listing itself is already subscribed, with the composition handler
+	delete(desired, watch.key)
+
+	for key := range watch.managed {
+		if !desired[key] {
+			r.informers.Unsubscribe(key, uid)
+			delete(watch.managed, key)
+		}
+	}
+
+	for key := range desired {
+		if watch.managed[key] {
+			continue
+		}
+		if err := r.informers.Subscribe(key, uid, handler); err != nil {
+			r.logger.Info(fmt.Sprintf("unable to watch managed resource: %s", err), "UID", uid, "gvr", key.GVR.String(), "name", key.Name, "namespace", key.Namespace)
+			continue
+		}
+		watch.managed[key] = true
+	}
+}
+
+// isClusterScoped reports whether the resource is cluster-scoped, resources that
+// cannot be mapped are assumed to be namespaced
+func (r *Registry) isClusterScoped(gvr schema.GroupVersionResource) bool {
+	gvk, err := r.mapper.KindFor(gvr)
+	if err != nil {
+		r.logger.Debug("unable to map managed resource to kind", "error", err, "gvr", gvr.String())
+		return false
+	}
+	mapping, err := r.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		r.logger.Debug("unable to map managed resource kind", "error", err, "gvk", gvk.String())
+		return false
+	}
+	return mapping.Scope.Name() == meta.RESTScopeNameRoot
+}
@@ -0,0 +1,37 @@
+package watcher
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// DefaultWatchedPaths are the fields of a composition compared between updates when
+// the CompositionReference does not list its own
+var DefaultWatchedPaths = []string{
+	"spec",
+	"status.managed",
+	"status.conditions",
+	"metadata.labels",
+	"metadata.annotations",
+	"metadata.deletionTimestamp",
+}
+
+// hasRelevantChange reports whether any of the dot separated paths differs between the
+// two objects. Resyncs and changes to resourceVersion or managedFields alone are not relevant.
+func hasRelevantChange(oldObj *unstructured.Unstructured, newObj *unstructured.Unstructured, paths []string) bool {
+	if len(paths) == 0 {
+		paths = DefaultWatchedPaths
+	}
+
+	for _, path := range paths {
+		fields := strings.Split(path, ".")
+		oldValue, _, _ := unstructured.NestedFieldNoCopy(oldObj.Object, fields...)
+		newValue, _, _ := unstructured.NestedFieldNoCopy(newObj.Object, fields...)
+		if !equality.Semantic.DeepEqual(oldValue, newValue) {
+			return true
+		}
+	}
+	return false
+}
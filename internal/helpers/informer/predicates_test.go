@@ -0,0 +1,125 @@
+package watcher
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newComposition() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "composition.krateo.io/v1",
+		"kind":       "FireworksApp",
+		"metadata": map[string]interface{}{
+			"name":            "app",
+			"namespace":       "demo",
+			"resourceVersion": "1",
+			"labels":          map[string]interface{}{"team": "a"},
+		},
+		"spec": map[string]interface{}{
+			"replicas": int64(1),
+		},
+		"status": map[string]interface{}{
+			"managed": []interface{}{
+				map[string]interface{}{"apiVersion": "apps/v1", "resource": "deployments", "name": "app", "namespace": "demo"},
+			},
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Ready", "status": "True"},
+			},
+		},
+	}}
+}
+
+func TestHasRelevantChange(t *testing.T) {
+	tests := []struct {
+		name   string
+		paths  []string
+		mutate func(obj *unstructured.Unstructured)
+		want   bool
+	}{
+		{
+			name:   "resync",
+			mutate: func(_ *unstructured.Unstructured) {},
+			want:   false,
+		},
+		{
+			name: "resourceVersion and managedFields only",
+			mutate: func(obj *unstructured.Unstructured) {
+				obj.SetResourceVersion("2")
+				_ = unstructured.SetNestedField(obj.Object, []interface{}{map[string]interface{}{"manager": "kubectl"}}, "metadata", "managedFields")
+			},
+			want: false,
+		},
+		{
+			name: "spec",
+			mutate: func(obj *unstructured.Unstructured) {
+				_ = unstructured.SetNestedField(obj.Object, int64(2), "spec", "replicas")
+			},
+			want: true,
+		},
+		{
+			name: "status.managed",
+			mutate: func(obj *unstructured.Unstructured) {
+				_ = unstructured.SetNestedSlice(obj.Object, []interface{}{}, "status", "managed")
+			},
+			want: true,
+		},
+		{
+			name: "labels",
+			mutate: func(obj *unstructured.Unstructured) {
+				obj.SetLabels(map[string]string{"team": "b"})
+			},
+			want: true,
+		},
+		{
+			name: "field appearing",
+			mutate: func(obj *unstructured.Unstructured) {
+				obj.SetAnnotations(map[string]string{"note": "x"})
+			},
+			want: true,
+		},
+		{
+			name: "unwatched field",
+			mutate: func(obj *unstructured.Unstructured) {
+				_ = unstructured.SetNestedField(obj.Object, "x", "status", "other")
+			},
+			want: false,
+		},
+		{
+			name:  "custom paths ignore the defaults",
+			paths: []string{"spec.replicas"},
+			mutate: func(obj *unstructured.Unstructured) {
+				_ = unstructured.SetNestedSlice(obj.Object, []interface{}{}, "status", "conditions")
+			},
+			want: false,
+		},
+		{
+			name:  "custom nested path",
+			paths: []string{"spec.replicas"},
+			mutate: func(obj *unstructured.Unstructured) {
+				_ = unstructured.SetNestedField(obj.Object, int64(3), "spec", "replicas")
+			},
+			want: true,
+		},
+		{
+			name:  "path through a non-map field",
+			paths: []string{"spec.replicas.value"},
+			mutate: func(obj *unstructured.Unstructured) {
+				_ = unstructured.SetNestedField(obj.Object, int64(3), "spec", "replicas")
+			},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldObj := newComposition()
+			newObj := oldObj.DeepCopy()
+			tt.mutate(newObj)
+
+			if got := hasRelevantChange(oldObj, newObj, tt.paths); got != tt.want {
+				t.Errorf("hasRelevantChange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,635 @@
+package watcher
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	watcher "github.com/krateoplatformops/composition-watcher/api/v1"
+	"github.com/krateoplatformops/composition-watcher/internal/helpers/filters"
+	"github.com/krateoplatformops/composition-watcher/internal/helpers/health"
+	httpHelper "github.com/krateoplatformops/composition-watcher/internal/helpers/http"
+	statusGetter "github.com/krateoplatformops/composition-watcher/internal/helpers/kube/compositions"
+	"github.com/krateoplatformops/composition-watcher/internal/helpers/metrics"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// queueName names the work queue in the controller-runtime workqueue metrics
+	queueName = "composition-trees"
+	// syncStatusQueueName names the queue of the sync status writes in the workqueue metrics
+	syncStatusQueueName = "compositionreference-sync-status"
+	// workers is the number of trees built and pushed concurrently
+	workers = 4
+	// maxRetries is the number of times a failed tree update is retried before being dropped
+	maxRetries = 10
+	// syncStatusDelay batches the sync status written to a CompositionReference after informer driven pushes
+	syncStatusDelay = 5 * time.Second
+)
+
+// Options configures the composition informers
+type Options struct {
+	// Scope sets what the shared informers list and watch
+	Scope Scope
+	// DebounceWindow coalesces the events of a composition within the window, zero disables it
+	DebounceWindow time.Duration
+	// DebounceMaxWait bounds how long the events of a composition can be held back, zero disables it
+	DebounceMaxWait time.Duration
+}
+
+// compositionWatch holds the subscriptions of a composition to the shared informers
+type compositionWatch struct {
+	compositionReference watcher.CompositionReference
+	reference            watcher.Reference
+	key                  ObjectKey
+	managed              map[ObjectKey]bool
+	stopChan             chan struct{}
+	startTime            time.Time
+	lastEventTime        time.Time
+	lastPush             *PushResult
+	// rollup is the health of the last tree pushed by the informers
+	rollup *health.Rollup
+}
+
+// pendingSync is the outcome of the last informer driven push of a composition, not yet
+// written to the status of its CompositionReference
+type pendingSync struct {
+	summary *statusGetter.TreeSummary
+	err     error
+}
+
+// Entry describes a composition tracked by the registry
+type Entry struct {
+	UID       types.UID
+	GVR       schema.GroupVersionResource
+	Namespace string
+	Name      string
+	// CompositionReference is the namespaced name of the owning CompositionReference
+	CompositionReference types.NamespacedName
+	// Generation is the generation of the CompositionReference the composition was started with
+	Generation int64
+	// Managed is the number of managed resources watched
+	Managed       int
+	StartTime     time.Time
+	HasSynced     bool
+	LastEventTime time.Time
+	// LastPush is nil until the first informer driven push
+	LastPush *PushResult
+}
+
+// PushResult is the outcome of an informer driven push to the webservice
+type PushResult struct {
+	Time     time.Time
+	TreeHash string
+	Error    string
+}
+
+// Registry tracks the compositions by UID and subscribes them to the shared informers. Informer
+// events enqueue the UID of the composition, the tree is then built and pushed by the workers, and
+// retried with exponential backoff on failure. It is safe for concurrent use by the reconcilers
+// and the event handlers.
+type Registry struct {
+	compositions map[types.UID]*compositionWatch
+	informers    *SharedInformers
+	queue        workqueue.TypedRateLimitingInterface[types.UID]
+	debouncer    *debouncer
+	mu           sync.Mutex
+	logger       logging.Logger
+	client       client.Client
+	apiReader    client.Reader
+	mapper       meta.RESTMapper
+	dynClient    dynamic.Interface
+	filters      *filters.Cache
+	health       *health.Registry
+
+	// syncQueue delays the sync status writes of the CompositionReferences with pending outcomes
+	syncQueue    workqueue.TypedDelayingInterface[types.NamespacedName]
+	syncMu       sync.Mutex
+	pendingSyncs map[types.NamespacedName]map[types.UID]pendingSync
+}
+
+// NewRegistry builds a registry, the API reader is used to read the CompositionReferences before
+// patching their status, so that the patches are not based on a stale cache. The RESTMapper
+// resolves the scope of the managed resources.
+func NewRegistry(log logging.Logger, kubeClient client.Client, apiReader client.Reader, mapper meta.RESTMapper, dynClient dynamic.Interface, opts Options, filterCache *filters.Cache, healthRegistry *health.Registry) *Registry {
+	r := &Registry{}
+	r.compositions = make(map[types.UID]*compositionWatch)
+	r.informers = NewSharedInformers(dynClient, opts.Scope, log)
+	r.queue = workqueue.NewTypedRateLimitingQueueWithConfig(
+		workqueue.DefaultTypedControllerRateLimiter[types.UID](),
+		workqueue.TypedRateLimitingQueueConfig[types.UID]{Name: queueName},
+	)
+	r.debouncer = newDebouncer(opts.DebounceWindow, opts.DebounceMaxWait, func(uid types.UID) {
+		r.queue.Add(uid)
+	})
+	r.logger = log
+	r.client = kubeClient
+	r.apiReader = apiReader
+	r.mapper = mapper
+	r.dynClient = dynClient
+	r.filters = filterCache
+	r.health = healthRegistry
+	r.syncQueue = workqueue.NewTypedDelayingQueueWithConfig(workqueue.TypedDelayingQueueConfig[types.NamespacedName]{Name: syncStatusQueueName})
+	r.pendingSyncs = make(map[types.NamespacedName]map[types.UID]pendingSync)
+	return r
+}
+
+// Start tracks a composition, it does nothing if the composition is already tracked
+func (r *Registry) Start(compositionReference watcher.CompositionReference, reference watcher.Reference, uid types.UID) error {
+	key, err := compositionKey(reference)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	if _, ok := r.compositions[uid]; ok {
+		r.mu.Unlock()
+		return nil
+	}
+	watch := &compositionWatch{
+		compositionReference: compositionReference,
+		reference:            reference,
+		key:                  key,
+		managed:              make(map[ObjectKey]bool),
+		stopChan:             make(chan struct{}),
+		startTime:            time.Now(),
+	}
+	r.compositions[uid] = watch
+	r.mu.Unlock()
+
+	err = r.informers.Subscribe(key, uid, r.compositionHandler(uid, compositionReference.Spec.WatchedPaths))
+	if err != nil {
+		r.mu.Lock()
+		delete(r.compositions, uid)
+		r.mu.Unlock()
+		return err
+	}
+
+	// The initial tree is pushed, and the managed resources are watched, once the composition has been listed
+	go func() {
+		if !cache.WaitForCacheSync(watch.stopChan, func() bool { return r.informers.HasSynced(key) }) {
+			return
+		}
+		item, ok := r.informers.Get(key)
+		if !ok {
+			return
+		}
+		if item.GetUID() != uid {
+			r.recreated(uid, item)
+			return
+		}
+		r.watchManaged(uid, item, func() { r.enqueue(uid) })
+		r.enqueue(uid)
+	}()
+	return nil
+}
+
+// compositionHandler handles the events of the composition with the given UID. Events carrying
+// another UID mean that the composition has been deleted and recreated with the same name, possibly
+// while the informer was disconnected.
+func (r *Registry) compositionHandler(uid types.UID, watchedPaths []string) cache.ResourceEventHandler {
+	enqueue := func() {
+		r.enqueue(uid)
+	}
+
+	return cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			item, ok := obj.(*unstructured.Unstructured)
+			if !ok {
+				return
+			}
+			if item.GetUID() != uid {
+				r.recreated(uid, item)
+				return
+			}
+			// The initial list is pushed once the informer has synced
+			if !isInInitialList {
+				r.watchManaged(uid, item, enqueue)
+				enqueue()
+			}
+		},
+		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+			item, ok := newObj.(*unstructured.Unstructured)
+			if !ok {
+				return
+			}
+			if item.GetUID() != uid {
+				r.recreated(uid, item)
+				return
+			}
+
+			// The managed resources are followed whatever the watched paths, it is a no-op when the list is unchanged
+			r.watchManaged(uid, item, enqueue)
+
+			// Only changes to the watched paths rebuild the tree
+			if oldItem, ok := oldObj.(*unstructured.Unstructured); ok && !hasRelevantChange(oldItem, item, watchedPaths) {
+				return
+			}
+
+			r.logger.Debug("Informer has received an update for object in list", "UID", uid)
+			enqueue()
+		},
+		DeleteFunc: func(obj interface{}) {
+			// The final state is unknown when the deletion was missed while the informer was disconnected
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			item, ok := obj.(*unstructured.Unstructured)
+			if !ok || item.GetUID() != uid {
+				return
+			}
+			r.deleted(uid)
+		},
+	}
+}
+
+// deleted stops tracking a deleted composition and deletes its tree from the webservice
+func (r *Registry) deleted(uid types.UID) {
+	if !r.Stop(uid) {
+		return
+	}
+	r.logger.Info("Informer for has been stopped and removed from the map", "UID", uid)
+
+	err := httpHelper.DeleteTree(string(uid))
+	if err != nil {
+		r.logger.Info(fmt.Sprintf("error with requested http resource: %s", err))
+		return
+	}
+	r.logger.Info("Deleted cache on webservice", "delete UID", uid)
+}
+
+// recreated moves the tracking of a composition from its old UID to the object that replaced it,
+// deleting the tree of the old one. The new tree is pushed once the informer has synced.
+func (r *Registry) recreated(oldUID types.UID, item *unstructured.Unstructured) {
+	r.mu.Lock()
+	watch, ok := r.compositions[oldUID]
+	if !ok {
+		r.mu.Unlock()
+		return
+	}
+	compositionReference, reference := watch.compositionReference, watch.reference
+	r.mu.Unlock()
+	r.logger.Info("Composition has been recreated", "old UID", oldUID, "UID", item.GetUID())
+
+	// The new subscription is added first, so that the shared informer is not stopped in between
+	if err := r.Start(compositionReference, reference, item.GetUID()); err != nil {
+		r.logger.Info(fmt.Sprintf("unable to track recreated composition: %s", err), "UID", item.GetUID())
+	}
+	r.deleted(oldUID)
+}
+
+// enqueue records an event of the composition and hands it to the debouncer
+func (r *Registry) enqueue(uid types.UID) {
+	r.mu.Lock()
+	if watch, ok := r.compositions[uid]; ok {
+		watch.lastEventTime = time.Now()
+	}
+	r.mu.Unlock()
+
+	r.debouncer.Trigger(uid)
+}
+
+// Run runs the workers, and the writer of the sync status, until the context is cancelled
+func (r *Registry) Run(ctx context.Context) error {
+	defer r.queue.ShutDown()
+	defer r.syncQueue.ShutDown()
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for r.processNextItem() {
+			}
+		}()
+	}
+	go func() {
+		for r.processNextSyncStatus(ctx) {
+		}
+	}()
+
+	<-ctx.Done()
+	return nil
+}
+
+func (r *Registry) processNextItem() bool {
+	uid, shutdown := r.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer r.queue.Done(uid)
+
+	err := r.sync(uid)
+	if err == nil {
+		r.queue.Forget(uid)
+		return true
+	}
+
+	if r.queue.NumRequeues(uid) < maxRetries {
+		r.logger.Debug("retrying tree update", "UID", uid, "error", err)
+		r.queue.AddRateLimited(uid)
+		metrics.TreeUpdateRetries.Inc()
+		return true
+	}
+
+	r.logger.Info(fmt.Sprintf("dropping tree update after %d retries: %s", maxRetries, err), "UID", uid)
+	r.queue.Forget(uid)
+	metrics.TreeUpdatesDropped.Inc()
+	return true
+}
+
+// sync builds the tree of a composition from the latest object seen by the informer and sends it to the webservice
+func (r *Registry) sync(uid types.UID) error {
+	// The fields are copied under the lock, Restart swaps them in place
+	r.mu.Lock()
+	watch, ok := r.compositions[uid]
+	if !ok {
+		r.mu.Unlock()
+		return nil
+	}
+	compositionReference, reference, key := watch.compositionReference, watch.reference, watch.key
+	r.mu.Unlock()
+
+	// The object may have been replaced by a composition with the same name
+	item, ok := r.informers.Get(key)
+	if !ok || item.GetUID() != uid {
+		return nil
+	}
+
+	// Compile errors are reported on the CompositionReference by the reconciler. Until Restart applies
+	// the new generation, the engine of the previous one is still cached.
+	engine, _ := r.filters.Get(&compositionReference)
+
+	rollup := health.NewRollup(statusGetter.HealthWeights(&compositionReference))
+	updatedData, summary, err := statusGetter.GetCompositionResourcesStatus(r.dynClient, item, reference, engine, compositionReference.Spec.Children, r.health, rollup, r.logger)
+	if err != nil {
+		err = fmt.Errorf("error retrieving updated status information for resources of composition uid %s: %w", uid, err)
+		r.recordPush(uid, "", nil, err)
+		r.recordSync(compositionReference, uid, nil, err)
+		return err
+	}
+
+	err = httpHelper.SendTree(string(uid), updatedData)
+	if err != nil {
+		err = fmt.Errorf("error with requested http resource: %w", err)
+	}
+	r.recordPush(uid, summary.Hash, rollup, err)
+	r.recordSync(compositionReference, uid, summary, err)
+	return err
+}
+
+func (r *Registry) recordPush(uid types.UID, treeHash string, rollup *health.Rollup, err error) {
+	result := &PushResult{Time: time.Now(), TreeHash: treeHash}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if watch, ok := r.compositions[uid]; ok {
+		watch.lastPush = result
+		if err == nil {
+			watch.rollup = rollup
+		}
+	}
+}
+
+// recordSync holds back the outcome of an informer driven push, the outcomes of all the compositions
+// of a CompositionReference are written together once syncStatusDelay has elapsed
+func (r *Registry) recordSync(compositionReference watcher.CompositionReference, uid types.UID, summary *statusGetter.TreeSummary, syncErr error) {
+	key := client.ObjectKeyFromObject(&compositionReference)
+
+	r.syncMu.Lock()
+	defer r.syncMu.Unlock()
+
+	pending, ok := r.pendingSyncs[key]
+	if !ok {
+		pending = make(map[types.UID]pendingSync)
+		r.pendingSyncs[key] = pending
+		r.syncQueue.AddAfter(key, syncStatusDelay)
+	}
+	pending[uid] = pendingSync{summary: summary, err: syncErr}
+}
+
+func (r *Registry) processNextSyncStatus(ctx context.Context) bool {
+	key, shutdown := r.syncQueue.Get()
+	if shutdown {
+		return false
+	}
+	defer r.syncQueue.Done(key)
+
+	r.syncMu.Lock()
+	pending := r.pendingSyncs[key]
+	delete(r.pendingSyncs, key)
+	r.syncMu.Unlock()
+
+	// The outcomes held back are dropped on shutdown, the reconciler records them on the next start
+	if ctx.Err() != nil {
+		return false
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.flushSync(ctx, key, pending)
+	})
+	if err != nil {
+		r.logger.Info(fmt.Sprintf("unable to record sync status: %s", err), "name", key.Name, "namespace", key.Namespace)
+	}
+	return true
+}
+
+// flushSync patches the sync status of a CompositionReference with the outcomes held back.
+// The figures are only written when the CompositionReference tracks a single composition, otherwise
+// they are totals maintained by the reconciler. The hash of the pushed trees is always recorded, so
+// that the reconciler does not push them again, and the health rollup is written once every tracked
+// composition has been pushed by the informers. Patches that would not change the status are skipped.
+// The patch fails with a conflict when the CompositionReference has been updated since it was read.
+func (r *Registry) flushSync(ctx context.Context, key types.NamespacedName, pending map[types.UID]pendingSync) error {
+	cr := &watcher.CompositionReference{}
+	if err := r.apiReader.Get(ctx, key, cr); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	original := cr.DeepCopy()
+
+	// Failures are recorded last, so that they are not hidden by the successes of other compositions
+	var syncErr error
+	for uid, push := range pending {
+		if push.err != nil {
+			syncErr = push.err
+			continue
+		}
+		if push.summary == nil {
+			continue
+		}
+		now := metav1.Now()
+		for i := range cr.Status.Matched {
+			if cr.Status.Matched[i].UID == string(uid) {
+				cr.Status.Matched[i].TreeHash = push.summary.Hash
+				cr.Status.Matched[i].LastSyncTime = &now
+			}
+		}
+		summary := push.summary
+		if len(cr.Status.Matched) > 1 {
+			summary = nil
+		}
+		statusGetter.RecordSync(&cr.Status, summary, nil)
+	}
+	if syncErr != nil {
+		statusGetter.RecordSync(&cr.Status, nil, syncErr)
+	}
+
+	if rollup, ok := r.rollup(cr); ok {
+		statusGetter.RecordHealth(cr, rollup)
+	}
+
+	if equality.Semantic.DeepEqual(original.Status, cr.Status) {
+		return nil
+	}
+	return r.client.Status().Patch(ctx, cr, client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{}))
+}
+
+// rollup merges the health of the last trees pushed by the informers for the compositions
+// matched by a CompositionReference, it is not available until all of them have been pushed
+func (r *Registry) rollup(cr *watcher.CompositionReference) (*health.Rollup, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := health.NewRollup(nil)
+	for _, matched := range cr.Status.Matched {
+		watch, ok := r.compositions[types.UID(matched.UID)]
+		if !ok || watch.rollup == nil {
+			return nil, false
+		}
+		res.Merge(watch.rollup)
+	}
+	return res, len(cr.Status.Matched) > 0
+}
+
+// Get returns the entry of a tracked composition
+func (r *Registry) Get(uid types.UID) (Entry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	watch, ok := r.compositions[uid]
+	if !ok {
+		return Entry{}, false
+	}
+	return r.entry(uid, watch), true
+}
+
+// List returns the entries of all the tracked compositions, sorted by namespace and name
+func (r *Registry) List() []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := make([]Entry, 0, len(r.compositions))
+	for uid, watch := range r.compositions {
+		res = append(res, r.entry(uid, watch))
+	}
+	slices.SortFunc(res, func(a, b Entry) int {
+		return cmp.Or(cmp.Compare(a.Namespace, b.Namespace), cmp.Compare(a.Name, b.Name), cmp.Compare(a.UID, b.UID))
+	})
+	return res
+}
+
+// entry builds the entry of a composition, the caller holds the lock
+func (r *Registry) entry(uid types.UID, watch *compositionWatch) Entry {
+	var lastPush *PushResult
+	if watch.lastPush != nil {
+		result := *watch.lastPush
+		lastPush = &result
+	}
+	return Entry{
+		UID:                  uid,
+		GVR:                  watch.key.GVR,
+		Namespace:            watch.key.Namespace,
+		Name:                 watch.key.Name,
+		CompositionReference: client.ObjectKeyFromObject(&watch.compositionReference),
+		Generation:           watch.compositionReference.GetGeneration(),
+		Managed:              len(watch.managed),
+		StartTime:            watch.startTime,
+		HasSynced:            r.informers.HasSynced(watch.key),
+		LastEventTime:        watch.lastEventTime,
+		LastPush:             lastPush,
+	}
+}
+
+// Informers returns the status of the running shared informers
+func (r *Registry) Informers() []InformerStatus {
+	return r.informers.List()
+}
+
+// Restart applies a new generation of its CompositionReference to a tracked composition. The
+// handler is swapped in place, so that the shared informers keep running and the history of the
+// composition is kept. A composition that is not tracked is started.
+func (r *Registry) Restart(compositionReference watcher.CompositionReference, reference watcher.Reference, uid types.UID) error {
+	key, err := compositionKey(reference)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	watch, ok := r.compositions[uid]
+	if !ok {
+		r.mu.Unlock()
+		return r.Start(compositionReference, reference, uid)
+	}
+	defer r.mu.Unlock()
+
+	// Subscribing replaces the handler of the composition, the new key is subscribed before the old one is dropped
+	if err := r.informers.Subscribe(key, uid, r.compositionHandler(uid, compositionReference.Spec.WatchedPaths)); err != nil {
+		return err
+	}
+	if key != watch.key {
+		r.informers.Unsubscribe(watch.key, uid)
+	}
+
+	watch.compositionReference = compositionReference
+	watch.reference = reference
+	watch.key = key
+	return nil
+}
+
+// compositionKey returns the key of the composition in the shared informers
+func compositionKey(reference watcher.Reference) (ObjectKey, error) {
+	gv, err := schema.ParseGroupVersion(reference.ApiVersion)
+	if err != nil {
+		return ObjectKey{}, fmt.Errorf("unable to parse GroupVersion from composition reference ApiVersion: %w", err)
+	}
+	gvr := schema.GroupVersionResource{
+		Group:    gv.Group,
+		Version:  gv.Version,
+		Resource: reference.Resource,
+	}
+	return ObjectKey{GVR: gvr, Namespace: reference.Namespace, Name: reference.Name}, nil
+}
+
+// Stop removes all the subscriptions of a composition, the shared informers
+// without subscriptions left are stopped
+func (r *Registry) Stop(uid types.UID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	watch, ok := r.compositions[uid]
+	if !ok {
+		return false
+	}
+	delete(r.compositions, uid)
+	close(watch.stopChan)
+	r.debouncer.Forget(uid)
+
+	r.informers.Unsubscribe(watch.key, uid)
+	for key := range watch.managed {
+		r.informers.Unsubscribe(key, uid)
+	}
+	return true
+}
@@ -0,0 +1,264 @@
+package watcher
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/krateoplatformops/composition-watcher/internal/helpers/metrics"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ObjectKey identifies an object watched through the shared informers
+type ObjectKey struct {
+	GVR       schema.GroupVersionResource
+	Namespace string
+	Name      string
+}
+
+// storeKey returns the key of the object in the informer store
+func (k ObjectKey) storeKey() string {
+	if k.Namespace == "" {
+		return k.Name
+	}
+	return k.Namespace + "/" + k.Name
+}
+
+// Scope sets what a shared informer lists and watches
+type Scope string
+
+const (
+	// ScopeNamespace shares one informer per GVR and namespace
+	ScopeNamespace Scope = "namespace"
+	// ScopeName runs one informer per object, listing and watching only that object
+	ScopeName Scope = "name"
+)
+
+// informerKey identifies an informer, the name is only set for name-scoped informers
+type informerKey struct {
+	gvr       schema.GroupVersionResource
+	namespace string
+	name      string
+}
+
+// sharedInformer is an informer on a GVR and namespace. Subscribers holds, for every
+// object key, the handlers of the compositions interested in it keyed by composition UID.
+type sharedInformer struct {
+	informer    cache.SharedIndexInformer
+	stopChan    chan struct{}
+	subscribers map[string]map[types.UID]cache.ResourceEventHandler
+}
+
+// SharedInformers runs a single informer per GVR and namespace for the whole process and fans
+// its events out to the compositions subscribed to the objects. An informer is stopped when
+// its last subscription is removed. With ScopeName there is an informer per object instead,
+// which is still shared by the compositions subscribed to that object.
+type SharedInformers struct {
+	dynClient dynamic.Interface
+	scope     Scope
+	logger    logging.Logger
+
+	mu        sync.Mutex
+	informers map[informerKey]*sharedInformer
+}
+
+func NewSharedInformers(dynClient dynamic.Interface, scope Scope, logger logging.Logger) *SharedInformers {
+	return &SharedInformers{
+		dynClient: dynClient,
+		scope:     scope,
+		logger:    logger,
+		informers: make(map[informerKey]*sharedInformer),
+	}
+}
+
+// Subscribe sends the events of an object to the handler of a composition, starting the informer if needed
+func (s *SharedInformers) Subscribe(key ObjectKey, subscriber types.UID, handler cache.ResourceEventHandler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ik := s.informerKey(key)
+	shared, ok := s.informers[ik]
+	if !ok {
+		var tweakListOptions dynamicinformer.TweakListOptionsFunc
+		if ik.name != "" {
+			tweakListOptions = func(opts *metav1.ListOptions) {
+				opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", ik.name).String()
+			}
+		}
+		fac := dynamicinformer.NewFilteredDynamicSharedInformerFactory(s.dynClient, 0, key.Namespace, tweakListOptions)
+		shared = &sharedInformer{
+			informer:    fac.ForResource(key.GVR).Informer(),
+			stopChan:    make(chan struct{}),
+			subscribers: make(map[string]map[types.UID]cache.ResourceEventHandler),
+		}
+
+		_, err := shared.informer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+			AddFunc: func(obj interface{}, isInInitialList bool) {
+				metrics.InformerEvents.WithLabelValues("add").Inc()
+				for _, handler := range s.handlers(shared, obj) {
+					handler.OnAdd(obj, isInInitialList)
+				}
+			},
+			UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+				metrics.InformerEvents.WithLabelValues("update").Inc()
+				for _, handler := range s.handlers(shared, newObj) {
+					handler.OnUpdate(oldObj, newObj)
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				metrics.InformerEvents.WithLabelValues("delete").Inc()
+				for _, handler := range s.handlers(shared, obj) {
+					handler.OnDelete(obj)
+				}
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("unable to add shared informer event handler: %w", err)
+		}
+
+		s.informers[ik] = shared
+		go shared.informer.Run(shared.stopChan)
+		metrics.ActiveInformers.Inc()
+		s.logger.Info("Started shared informer", "gvr", key.GVR.String(), "namespace", key.Namespace, "name", ik.name)
+	}
+
+	subscribers, ok := shared.subscribers[key.storeKey()]
+	if !ok {
+		subscribers = make(map[types.UID]cache.ResourceEventHandler)
+		shared.subscribers[key.storeKey()] = subscribers
+	}
+	subscribers[subscriber] = handler
+	return nil
+}
+
+// Unsubscribe removes the handler of a composition, stopping the informer when it was the last subscription
+func (s *SharedInformers) Unsubscribe(key ObjectKey, subscriber types.UID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ik := s.informerKey(key)
+	shared, ok := s.informers[ik]
+	if !ok {
+		return
+	}
+
+	if subscribers, ok := shared.subscribers[key.storeKey()]; ok {
+		delete(subscribers, subscriber)
+		if len(subscribers) == 0 {
+			delete(shared.subscribers, key.storeKey())
+		}
+	}
+
+	if len(shared.subscribers) == 0 {
+		close(shared.stopChan)
+		delete(s.informers, ik)
+		metrics.ActiveInformers.Dec()
+		s.logger.Info("Stopped shared informer", "gvr", key.GVR.String(), "namespace", key.Namespace, "name", ik.name)
+	}
+}
+
+// Get returns the object from the informer cache
+func (s *SharedInformers) Get(key ObjectKey) (*unstructured.Unstructured, bool) {
+	s.mu.Lock()
+	shared, ok := s.informers[s.informerKey(key)]
+	s.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	obj, exists, err := shared.informer.GetStore().GetByKey(key.storeKey())
+	if err != nil || !exists {
+		return nil, false
+	}
+	item, ok := obj.(*unstructured.Unstructured)
+	return item, ok
+}
+
+// HasSynced reports whether the informer of the object has completed its initial list
+func (s *SharedInformers) HasSynced(key ObjectKey) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	shared, ok := s.informers[s.informerKey(key)]
+	return ok && shared.informer.HasSynced()
+}
+
+// InformerStatus describes a running shared informer
+type InformerStatus struct {
+	GVR       schema.GroupVersionResource
+	Namespace string
+	// Name is only set with ScopeName
+	Name      string
+	HasSynced bool
+	// Objects is the number of objects subscribed to
+	Objects int
+	// Subscribers are the UIDs of the compositions subscribed to the objects
+	Subscribers []types.UID
+}
+
+// List returns the status of the running informers, sorted by GVR, namespace and name
+func (s *SharedInformers) List() []InformerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]InformerStatus, 0, len(s.informers))
+	for ik, shared := range s.informers {
+		status := InformerStatus{
+			GVR:       ik.gvr,
+			Namespace: ik.namespace,
+			Name:      ik.name,
+			HasSynced: shared.informer.HasSynced(),
+			Objects:   len(shared.subscribers),
+		}
+		for _, subscribers := range shared.subscribers {
+			for uid := range subscribers {
+				if !slices.Contains(status.Subscribers, uid) {
+					status.Subscribers = append(status.Subscribers, uid)
+				}
+			}
+		}
+		slices.Sort(status.Subscribers)
+		res = append(res, status)
+	}
+	slices.SortFunc(res, func(a, b InformerStatus) int {
+		return cmp.Or(
+			cmp.Compare(a.GVR.String(), b.GVR.String()),
+			cmp.Compare(a.Namespace, b.Namespace),
+			cmp.Compare(a.Name, b.Name),
+		)
+	})
+	return res
+}
+
+func (s *SharedInformers) informerKey(key ObjectKey) informerKey {
+	ik := informerKey{gvr: key.GVR, namespace: key.Namespace}
+	if s.scope == ScopeName {
+		ik.name = key.Name
+	}
+	return ik
+}
+
+// handlers returns the handlers subscribed to an object, they are called without holding the lock
+func (s *SharedInformers) handlers(shared *sharedInformer, obj interface{}) []cache.ResourceEventHandler {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]cache.ResourceEventHandler, 0, len(shared.subscribers[key]))
+	for _, handler := range shared.subscribers[key] {
+		res = append(res, handler)
+	}
+	return res
+}
@@ -0,0 +1,113 @@
+package compositions
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+
+	watcher "github.com/krateoplatformops/composition-watcher/api/v1"
+	"github.com/krateoplatformops/composition-watcher/internal/helpers/filters"
+	"github.com/krateoplatformops/composition-watcher/internal/helpers/health"
+)
+
+// childrenExpander follows ownerReferences down from the managed resources.
+// Lists are cached for the duration of a single tree build.
+type childrenExpander struct {
+	dynClient dynamic.Interface
+	children  watcher.Children
+	engine    *filters.Engine
+	health    *health.Registry
+	logger    logging.Logger
+	lists     map[string][]unstructured.Unstructured
+	visited   map[types.UID]bool
+	summary   *TreeSummary
+}
+
+func newChildrenExpander(dynClient dynamic.Interface, children watcher.Children, engine *filters.Engine, healthRegistry *health.Registry, visited map[types.UID]bool, summary *TreeSummary, logger logging.Logger) *childrenExpander {
+	return &childrenExpander{
+		dynClient: dynClient,
+		children:  children,
+		engine:    engine,
+		health:    healthRegistry,
+		logger:    logger,
+		lists:     make(map[string][]unstructured.Unstructured),
+		visited:   visited,
+		summary:   summary,
+	}
+}
+
+// expand adds to the tree the resources owned by parent, recursing until depth is exhausted
+func (x *childrenExpander) expand(tree *ResourceTreeJson, parent treeNode, depth int) {
+	if depth <= 0 {
+		return
+	}
+	x.visited[parent.obj.GetUID()] = true
+
+	for _, childResource := range x.children.Resources {
+		items := x.list(childResource, parent.obj.GetNamespace())
+		for i := range items {
+			child := &items[i]
+			if x.visited[child.GetUID()] || !isOwnedBy(child, parent.obj.GetUID()) {
+				continue
+			}
+
+			reference := watcher.Reference{
+				ApiVersion: childResource.ApiVersion,
+				Resource:   childResource.Resource,
+				Name:       child.GetName(),
+				Namespace:  child.GetNamespace(),
+			}
+			if x.engine.IsFiltered(reference, child, false) {
+				x.summary.ExcludedCount++
+				continue
+			}
+			x.visited[child.GetUID()] = true
+
+			resourceNodeJsonSpec, resourceNodeJsonStatus := newResourceNode(reference, child, parent.reference, x.health.Assess(child))
+			resourceNodeJsonStatus.ParentRefs = append(resourceNodeJsonStatus.ParentRefs, parent.status)
+			tree.Spec.Tree = append(tree.Spec.Tree, resourceNodeJsonSpec)
+			tree.Status = append(tree.Status, resourceNodeJsonStatus)
+
+			x.expand(tree, treeNode{reference: reference, obj: child, status: resourceNodeJsonStatus}, depth-1)
+		}
+	}
+}
+
+func (x *childrenExpander) list(childResource watcher.ChildResource, namespace string) []unstructured.Unstructured {
+	key := fmt.Sprintf("%s/%s/%s", childResource.ApiVersion, childResource.Resource, namespace)
+	if items, ok := x.lists[key]; ok {
+		return items
+	}
+
+	gv, err := schema.ParseGroupVersion(childResource.ApiVersion)
+	if err != nil {
+		x.logger.Info(fmt.Sprintf("could not parse Group/Version of child resource: %s", err), "apiVersion", childResource.ApiVersion)
+		x.lists[key] = nil
+		return nil
+	}
+
+	list, err := x.dynClient.Resource(gv.WithResource(childResource.Resource)).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		x.logger.Info(fmt.Sprintf("error listing child resources: %s", err), "group", gv.Group, "version", gv.Version, "resource", childResource.Resource, "namespace", namespace)
+		x.lists[key] = nil
+		return nil
+	}
+
+	x.lists[key] = list.Items
+	return list.Items
+}
+
+func isOwnedBy(obj *unstructured.Unstructured, uid types.UID) bool {
+	for _, owner := range obj.GetOwnerReferences() {
+		if owner.UID == uid {
+			return true
+		}
+	}
+	return false
+}
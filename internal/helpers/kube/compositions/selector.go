@@ -0,0 +1,68 @@
+package compositions
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	watcher "github.com/krateoplatformops/composition-watcher/api/v1"
+)
+
+var namespacesGVR = schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}
+
+// ListCompositions returns every composition matched by the selector, honoring both
+// the label selector and the namespace selector
+func ListCompositions(ctx context.Context, dynClient dynamic.Interface, selector watcher.Selector) ([]unstructured.Unstructured, error) {
+	gv, err := schema.ParseGroupVersion(selector.ApiVersion)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse GroupVersion from composition selector ApiVersion: %w", err)
+	}
+	gvr := schema.GroupVersionResource{
+		Group:    gv.Group,
+		Version:  gv.Version,
+		Resource: selector.Resource,
+	}
+
+	labelSelector := labels.Everything()
+	if selector.LabelSelector != nil {
+		labelSelector, err = metav1.LabelSelectorAsSelector(selector.LabelSelector)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse composition selector labelSelector: %w", err)
+		}
+	}
+
+	var allowedNamespaces map[string]bool
+	if selector.NamespaceSelector != nil {
+		namespaceSelector, err := metav1.LabelSelectorAsSelector(selector.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse composition selector namespaceSelector: %w", err)
+		}
+		namespaces, err := dynClient.Resource(namespacesGVR).List(ctx, metav1.ListOptions{LabelSelector: namespaceSelector.String()})
+		if err != nil {
+			return nil, fmt.Errorf("unable to list namespaces matching %s: %w", namespaceSelector.String(), err)
+		}
+		allowedNamespaces = make(map[string]bool, len(namespaces.Items))
+		for _, ns := range namespaces.Items {
+			allowedNamespaces[ns.GetName()] = true
+		}
+	}
+
+	list, err := dynClient.Resource(gvr).Namespace(selector.Namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list %s with apiVersion %s matching %s: %w", selector.Resource, selector.ApiVersion, labelSelector.String(), err)
+	}
+
+	res := make([]unstructured.Unstructured, 0, len(list.Items))
+	for _, item := range list.Items {
+		if allowedNamespaces != nil && !allowedNamespaces[item.GetNamespace()] {
+			continue
+		}
+		res = append(res, item)
+	}
+	return res, nil
+}
@@ -2,19 +2,47 @@ package compositions
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"time"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
 
 	watcher "github.com/krateoplatformops/composition-watcher/api/v1"
+	"github.com/krateoplatformops/composition-watcher/internal/helpers/filters"
+	"github.com/krateoplatformops/composition-watcher/internal/helpers/health"
+	"github.com/krateoplatformops/composition-watcher/internal/helpers/metrics"
 	"github.com/krateoplatformops/provider-runtime/pkg/logging"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func GetCompositionResourcesStatus(dynClient *dynamic.DynamicClient, obj *unstructured.Unstructured, compositionReference watcher.Reference, excludes []watcher.Exclude, logger logging.Logger) ([]byte, error) {
+// maxNestingDepth bounds the expansion of compositions managed by other compositions
+const maxNestingDepth = 10
+
+// TreeSummary describes a built tree
+type TreeSummary struct {
+	// ResourceCount is the number of nodes in the tree
+	ResourceCount int
+	// ExcludedCount is the number of resources left out by the filters
+	ExcludedCount int
+	// Unreachable lists the resources that could not be fetched, missing resources are not included
+	Unreachable []watcher.Reference
+	// Hash identifies the content of the tree, regardless of when it was built
+	Hash string
+}
+
+func GetCompositionResourcesStatus(dynClient dynamic.Interface, obj *unstructured.Unstructured, compositionReference watcher.Reference, engine *filters.Engine, children *watcher.Children, healthRegistry *health.Registry, rollup *health.Rollup, logger logging.Logger) ([]byte, *TreeSummary, error) {
+	start := time.Now()
+	defer func() {
+		metrics.TreeBuildDuration.Observe(time.Since(start).Seconds())
+	}()
+
 	resourceTreeJson := ResourceTreeJson{}
 	resourceTreeJson.CreationTimestamp = metav1.Now()
 
@@ -23,148 +51,295 @@ func GetCompositionResourcesStatus(dynClient *dynamic.DynamicClient, obj *unstru
 
 	status, found, err := unstructured.NestedMap(obj.Object, "status")
 	if err != nil {
-		return nil, fmt.Errorf("error accessing 'status' field: %w", err)
+		return nil, nil, fmt.Errorf("error accessing 'status' field: %w", err)
 	}
 	if !found {
-		return nil, fmt.Errorf("could not find 'status' field in composition object")
+		return nil, nil, fmt.Errorf("could not find 'status' field in composition object")
 	}
 
 	managed, found := status["managed"]
 	if !found {
-		return nil, fmt.Errorf("could not find 'managed' field in composition object")
+		return nil, nil, fmt.Errorf("could not find 'managed' field in composition object")
 	}
 
-	var managedResourceList []watcher.Reference
-
 	// Check if managed is a slice
 	managedSlice, ok := managed.([]interface{})
 	if !ok {
-		return nil, fmt.Errorf("'managed' field is not a slice as expected")
+		return nil, nil, fmt.Errorf("'managed' field is not a slice as expected")
 	}
 
-	for _, m := range managedSlice {
-		if mMap, ok := m.(map[string]interface{}); ok {
-			ref := watcher.Reference{
-				ApiVersion: mMap["apiVersion"].(string),
-				Resource:   mMap["resource"].(string),
-				Name:       mMap["name"].(string),
-				Namespace:  mMap["namespace"].(string),
+	summary := &TreeSummary{}
+	builder := &treeBuilder{
+		dynClient: dynClient,
+		summary:   summary,
+		engine:    engine,
+		health:    healthRegistry,
+		logger:    logger,
+		tree:      &resourceTreeJson,
+		visited:   map[types.UID]bool{obj.GetUID(): true},
+	}
+
+	// When the composition itself is filtered out, its managed resources point to an empty parent
+	compositionNode := treeNode{reference: compositionReference, obj: obj, status: &ResourceNodeStatus{}}
+	var compositionSpec *ResourceNode
+	if engine.IsFiltered(compositionReference, obj, true) {
+		summary.ExcludedCount++
+	} else {
+		resourceNodeJsonSpec, resourceNodeJsonStatus := newResourceNode(compositionReference, obj, compositionReference, healthRegistry.Assess(obj))
+		compositionSpec = &resourceNodeJsonSpec
+		compositionNode.status = resourceNodeJsonStatus
+	}
+
+	builder.addManaged(compositionNode, toReferences(managedSlice), 0)
+
+	if compositionSpec != nil {
+		resourceTreeJson.Spec.Tree = append(resourceTreeJson.Spec.Tree, *compositionSpec)
+		resourceTreeJson.Status = append(resourceTreeJson.Status, compositionNode.status)
+	}
+
+	// Children are expanded once all the managed resources are known, each child only has its owner as parent
+	if children != nil && children.Depth > 0 {
+		expander := newChildrenExpander(dynClient, *children, engine, healthRegistry, builder.visited, summary, logger)
+		for _, node := range builder.managedNodes {
+			expander.expand(&resourceTreeJson, node, children.Depth)
+		}
+	}
+
+	summary.ResourceCount = len(resourceTreeJson.Status)
+
+	// The rollup is optional, it is shared by the trees of all the compositions tracked by a CompositionReference
+	if rollup != nil {
+		for _, node := range resourceTreeJson.Status {
+			if node.Health == nil {
+				continue
 			}
-			managedResourceList = append(managedResourceList, ref)
+			rollup.Add(schema.FromAPIVersionAndKind(node.Version, node.Kind).GroupKind(), health.Status(node.Health.Status))
+		}
+	}
+
+	resourceTree := ResourceTree{
+		CompositionId: string(obj.GetUID()),
+		Resources:     resourceTreeJson,
+	}
+
+	summary.Hash, err = hashTree(resourceTree)
+	if err != nil {
+		return []byte{}, nil, fmt.Errorf("error hashing composition resources status: %w", err)
+	}
+
+	jsonData, err := json.Marshal(resourceTree)
+	if err != nil {
+		return []byte{}, nil, fmt.Errorf("error marshaling composition resources status: %w", err)
+	}
+	logger.Debug("webservice response", "json", string(jsonData))
+	return jsonData, summary, nil
+}
+
+// hashTree hashes the content of a tree, leaving out its creation timestamp and the resource
+// versions of the nodes, which also change on metadata-only writes like managedFields updates
+func hashTree(resourceTree ResourceTree) (string, error) {
+	resourceVersions := make(map[*ResourceNodeStatus]*string)
+	var clearResourceVersions func(nodes []*ResourceNodeStatus)
+	clearResourceVersions = func(nodes []*ResourceNodeStatus) {
+		for _, node := range nodes {
+			if _, ok := resourceVersions[node]; ok || node == nil {
+				continue
+			}
+			resourceVersions[node] = node.ResourceVersion
+			node.ResourceVersion = nil
+			clearResourceVersions(node.ParentRefs)
+		}
+	}
+	clearResourceVersions(resourceTree.Resources.Status)
+	defer func() {
+		for node, resourceVersion := range resourceVersions {
+			node.ResourceVersion = resourceVersion
 		}
+	}()
+
+	h := sha256.New()
+	err := json.NewEncoder(h).Encode([]interface{}{resourceTree.CompositionId, resourceTree.Resources.Spec, resourceTree.Resources.Status})
+	if err != nil {
+		return "", err
 	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
-	managedResourceList = append(managedResourceList, compositionReference)
+// treeBuilder adds managed resources to the tree, expanding nested compositions.
+// A resource is added at most once, which also protects against cycles.
+type treeBuilder struct {
+	dynClient    dynamic.Interface
+	summary      *TreeSummary
+	engine       *filters.Engine
+	health       *health.Registry
+	logger       logging.Logger
+	tree         *ResourceTreeJson
+	visited      map[types.UID]bool
+	managedNodes []treeNode
+}
 
+// addManaged adds the resources managed by a composition, with the composition as parent.
+// Managed resources that have a 'status.managed' list are compositions themselves and are expanded in turn.
+func (b *treeBuilder) addManaged(composition treeNode, managedResourceList []watcher.Reference, depth int) {
 	for _, managedResource := range managedResourceList {
-		skip := false
-		for _, exclude := range excludes {
-			if shouldItSkip(exclude, managedResource) {
-				skip = true
-				break
-			}
+		if b.engine.IsExcludedByReference(managedResource) {
+			b.summary.ExcludedCount++
+			continue
 		}
-		if skip {
+
+		unstructuredRes, err := b.fetch(managedResource)
+		if apierrors.IsNotFound(err) {
+			resourceNodeJsonSpec, resourceNodeJsonStatus := newMissingResourceNode(managedResource, composition.reference)
+			resourceNodeJsonStatus.ParentRefs = append(resourceNodeJsonStatus.ParentRefs, composition.status)
+			b.tree.Spec.Tree = append(b.tree.Spec.Tree, resourceNodeJsonSpec)
+			b.tree.Status = append(b.tree.Status, resourceNodeJsonStatus)
 			continue
 		}
-		gv, err := schema.ParseGroupVersion(managedResource.ApiVersion)
 		if err != nil {
-			return nil, fmt.Errorf("could not parse Group/Version of managed resource: %w", err)
+			b.summary.Unreachable = append(b.summary.Unreachable, managedResource)
+			continue
 		}
 
-		gvr := schema.GroupVersionResource{
-			Group:    gv.Group,
-			Version:  gv.Version,
-			Resource: managedResource.Resource,
+		if b.visited[unstructuredRes.GetUID()] {
+			continue
 		}
 
-		unstructuredRes, err := dynClient.Resource(gvr).Namespace(managedResource.Namespace).Get(context.TODO(), managedResource.Name, metav1.GetOptions{})
-		if err != nil {
-			logger.Debug("error fetching resource status, trying with cluster-scoped", "error", err, "group", gvr.Group, "version", gvr.Version, "resource", gvr.Resource, "name", managedResource.Name, "namespace", managedResource.Namespace)
-			unstructuredRes, err = dynClient.Resource(gvr).Get(context.TODO(), managedResource.Name, metav1.GetOptions{})
-			if err != nil {
-				logger.Info(fmt.Sprintf("error fetching resource status: %s", err), "group", gvr.Group, "version", gvr.Version, "resource", gvr.Resource, "name", managedResource.Name, "namespace", "")
-				continue
-			}
+		if b.engine.IsFiltered(managedResource, unstructuredRes, false) {
+			b.summary.ExcludedCount++
+			continue
+		}
+		b.visited[unstructuredRes.GetUID()] = true
+
+		resourceNodeJsonSpec, resourceNodeJsonStatus := newResourceNode(managedResource, unstructuredRes, composition.reference, b.health.Assess(unstructuredRes))
+		resourceNodeJsonStatus.ParentRefs = append(resourceNodeJsonStatus.ParentRefs, composition.status)
 
+		b.tree.Spec.Tree = append(b.tree.Spec.Tree, resourceNodeJsonSpec)
+		b.tree.Status = append(b.tree.Status, resourceNodeJsonStatus)
+
+		node := treeNode{reference: managedResource, obj: unstructuredRes, status: resourceNodeJsonStatus}
+		b.managedNodes = append(b.managedNodes, node)
+
+		nestedManaged, found, _ := unstructured.NestedSlice(unstructuredRes.Object, "status", "managed")
+		if !found {
+			continue
 		}
+		if depth+1 >= maxNestingDepth {
+			b.logger.Info("maximum nesting depth reached, nested composition not expanded", "name", managedResource.Name, "namespace", managedResource.Namespace, "depth", maxNestingDepth)
+			continue
+		}
+		b.addManaged(node, toReferences(nestedManaged), depth+1)
+	}
+}
+
+// fetch gets a managed resource, falling back to cluster-scoped when the namespaced lookup fails
+func (b *treeBuilder) fetch(managedResource watcher.Reference) (*unstructured.Unstructured, error) {
+	gv, err := schema.ParseGroupVersion(managedResource.ApiVersion)
+	if err != nil {
+		b.logger.Info(fmt.Sprintf("could not parse Group/Version of managed resource: %s", err), "apiVersion", managedResource.ApiVersion, "name", managedResource.Name)
+		return nil, err
+	}
+
+	gvr := schema.GroupVersionResource{
+		Group:    gv.Group,
+		Version:  gv.Version,
+		Resource: managedResource.Resource,
+	}
 
-		var health Health
-
-		// Extract status if available
-		if unstructuredStatus, found, _ := unstructured.NestedMap(unstructuredRes.Object, "status"); found {
-			if conditions, ok := unstructuredStatus["conditions"].([]interface{}); ok && len(conditions) > 0 {
-				lastCondition := conditions[len(conditions)-1].(map[string]interface{})
-				if value, ok := lastCondition["status"]; ok {
-					health.Status = value.(string)
-				}
-				if value, ok := lastCondition["type"]; ok {
-					health.Type = value.(string)
-				}
-				if value, ok := lastCondition["reason"]; ok {
-					health.Reason = value.(string)
-				}
-				if value, ok := lastCondition["message"]; ok {
-					health.Message = value.(string)
-				}
+	unstructuredRes, err := b.dynClient.Resource(gvr).Namespace(managedResource.Namespace).Get(context.TODO(), managedResource.Name, metav1.GetOptions{})
+	if err != nil {
+		b.logger.Debug("error fetching resource status, trying with cluster-scoped", "error", err, "group", gvr.Group, "version", gvr.Version, "resource", gvr.Resource, "name", managedResource.Name, "namespace", managedResource.Namespace)
+		unstructuredRes, err = b.dynClient.Resource(gvr).Get(context.TODO(), managedResource.Name, metav1.GetOptions{})
+		if err != nil {
+			b.logger.Info(fmt.Sprintf("error fetching resource status: %s", err), "group", gvr.Group, "version", gvr.Version, "resource", gvr.Resource, "name", managedResource.Name, "namespace", "")
+			if !apierrors.IsNotFound(err) {
+				metrics.ManagedResourcesFailed.WithLabelValues(gvr.Group, gvr.Version, gvr.Resource).Inc()
 			}
+			return nil, err
 		}
+	}
+	metrics.ManagedResourcesFetched.WithLabelValues(gvr.Group, gvr.Version, gvr.Resource).Inc()
+	return unstructuredRes, nil
+}
+
+// ManagedReferences returns the resources listed in the 'status.managed' of a composition
+func ManagedReferences(obj *unstructured.Unstructured) []watcher.Reference {
+	managedSlice, _, _ := unstructured.NestedSlice(obj.Object, "status", "managed")
+	return toReferences(managedSlice)
+}
 
-		resourceNodeJsonSpec := ResourceNode{}
-		resourceNodeJsonSpec.APIVersion = managedResource.ApiVersion
-		resourceNodeJsonSpec.Resource = managedResource.Resource
-		resourceNodeJsonSpec.Name = managedResource.Name
-		resourceNodeJsonSpec.Namespace = managedResource.Namespace
-		resourceNodeJsonSpec.ParentRefs = []watcher.Reference{compositionReference}
-		resourceTreeJson.Spec.Tree = append(resourceTreeJson.Spec.Tree, resourceNodeJsonSpec)
-
-		resourceNodeJsonStatus := ResourceNodeStatus{}
-		time := unstructuredRes.GetCreationTimestamp()
-		resourceNodeJsonStatus.CreatedAt = &time
-		resourceNodeJsonStatus.Kind = unstructuredRes.GetKind()
-		resourceNodeJsonStatus.Version = unstructuredRes.GetAPIVersion()
-		resourceNodeJsonStatus.Name = managedResource.Name
-		resourceNodeJsonStatus.Namespace = managedResource.Namespace
-		resourceNodeJsonStatus.Health = &health
-		uidString := string(unstructuredRes.GetUID())
-		resourceNodeJsonStatus.UID = &uidString
-		resourceVersionString := unstructuredRes.GetResourceVersion()
-		resourceNodeJsonStatus.ResourceVersion = &resourceVersionString
-		resourceNodeJsonStatus.ParentRefs = []*ResourceNodeStatus{}
-
-		resourceTreeJson.Spec.Tree = append(resourceTreeJson.Spec.Tree, resourceNodeJsonSpec)
-		resourceTreeJson.Status = append(resourceTreeJson.Status, &resourceNodeJsonStatus)
-	}
-
-	compositionStatus := &ResourceNodeStatus{}
-	skipValue := -1
-	// Find the composition in the resourceTreeJson.Status
-	// Copy its pointer and position
-	for i, status := range resourceTreeJson.Status {
-		if obj.GetKind() == status.Kind && obj.GetAPIVersion() == status.Version {
-			skipValue = i
-			compositionStatus = status
-			break
+// toReferences converts the entries of a 'status.managed' list
+func toReferences(managedSlice []interface{}) []watcher.Reference {
+	var managedResourceList []watcher.Reference
+	for _, m := range managedSlice {
+		if mMap, ok := m.(map[string]interface{}); ok {
+			ref := watcher.Reference{}
+			ref.ApiVersion, _ = mMap["apiVersion"].(string)
+			ref.Resource, _ = mMap["resource"].(string)
+			ref.Name, _ = mMap["name"].(string)
+			ref.Namespace, _ = mMap["namespace"].(string)
+			managedResourceList = append(managedResourceList, ref)
 		}
 	}
+	return managedResourceList
+}
 
-	// Add the composition status to each resourceTreeJson.Status, except the one of the composition
-	for i := range resourceTreeJson.Status {
-		if skipValue != i {
-			resourceTreeJson.Status[i].ParentRefs = append(resourceTreeJson.Status[i].ParentRefs, compositionStatus)
-		}
+// treeNode is a resource already added to the tree, together with the object it was built from
+type treeNode struct {
+	reference watcher.Reference
+	obj       *unstructured.Unstructured
+	status    *ResourceNodeStatus
+}
 
-	}
+// newResourceNode builds the spec and status entries of a resource, the status parents are left to the caller
+func newResourceNode(reference watcher.Reference, obj *unstructured.Unstructured, parent watcher.Reference, result health.Result) (ResourceNode, *ResourceNodeStatus) {
+	resourceNodeJsonSpec := ResourceNode{}
+	resourceNodeJsonSpec.APIVersion = reference.ApiVersion
+	resourceNodeJsonSpec.Resource = reference.Resource
+	resourceNodeJsonSpec.Name = reference.Name
+	resourceNodeJsonSpec.Namespace = reference.Namespace
+	resourceNodeJsonSpec.ParentRefs = []watcher.Reference{parent}
 
-	resourceTree := ResourceTree{
-		CompositionId: string(obj.GetUID()),
-		Resources:     resourceTreeJson,
-	}
+	resourceNodeJsonStatus := ResourceNodeStatus{}
+	time := obj.GetCreationTimestamp()
+	resourceNodeJsonStatus.CreatedAt = &time
+	resourceNodeJsonStatus.Kind = obj.GetKind()
+	resourceNodeJsonStatus.Version = obj.GetAPIVersion()
+	resourceNodeJsonStatus.Name = reference.Name
+	resourceNodeJsonStatus.Namespace = reference.Namespace
+	resourceNodeJsonStatus.Health = toHealth(result)
+	uidString := string(obj.GetUID())
+	resourceNodeJsonStatus.UID = &uidString
+	resourceVersionString := obj.GetResourceVersion()
+	resourceNodeJsonStatus.ResourceVersion = &resourceVersionString
+	resourceNodeJsonStatus.ParentRefs = []*ResourceNodeStatus{}
 
-	jsonData, err := json.Marshal(resourceTree)
-	if err != nil {
-		return []byte{}, fmt.Errorf("error marshaling composition resources status: %w", err)
+	return resourceNodeJsonSpec, &resourceNodeJsonStatus
+}
+
+// newMissingResourceNode builds the entries of a managed resource that does not exist in the cluster
+func newMissingResourceNode(reference watcher.Reference, parent watcher.Reference) (ResourceNode, *ResourceNodeStatus) {
+	resourceNodeJsonSpec := ResourceNode{}
+	resourceNodeJsonSpec.APIVersion = reference.ApiVersion
+	resourceNodeJsonSpec.Resource = reference.Resource
+	resourceNodeJsonSpec.Name = reference.Name
+	resourceNodeJsonSpec.Namespace = reference.Namespace
+	resourceNodeJsonSpec.ParentRefs = []watcher.Reference{parent}
+
+	resourceNodeJsonStatus := ResourceNodeStatus{}
+	resourceNodeJsonStatus.Version = reference.ApiVersion
+	resourceNodeJsonStatus.Name = reference.Name
+	resourceNodeJsonStatus.Namespace = reference.Namespace
+	resourceNodeJsonStatus.Health = toHealth(health.Result{Status: health.Missing, Message: "resource not found"})
+	resourceNodeJsonStatus.ParentRefs = []*ResourceNodeStatus{}
+
+	return resourceNodeJsonSpec, &resourceNodeJsonStatus
+}
+
+func toHealth(result health.Result) *Health {
+	return &Health{
+		Status:  string(result.Status),
+		Type:    result.Type,
+		Reason:  result.Reason,
+		Message: result.Message,
 	}
-	logger.Debug("webservice response", "json", string(jsonData))
-	return jsonData, nil
 }
@@ -0,0 +1,69 @@
+package compositions
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	watcher "github.com/krateoplatformops/composition-watcher/api/v1"
+	"github.com/krateoplatformops/composition-watcher/internal/helpers/health"
+)
+
+// maxUnreachableResources bounds the unreachable resources listed in the status
+const maxUnreachableResources = 50
+
+// Add accumulates the summary of another tree, when a CompositionReference tracks many compositions
+func (s *TreeSummary) Add(other *TreeSummary) {
+	if other == nil {
+		return
+	}
+	s.ResourceCount += other.ResourceCount
+	s.ExcludedCount += other.ExcludedCount
+	s.Unreachable = append(s.Unreachable, other.Unreachable...)
+}
+
+// RecordSync writes the outcome of a push to the status of a CompositionReference.
+// A failed push only sets the error, leaving the figures of the last successful one.
+func RecordSync(status *watcher.CompositionReferenceStatus, summary *TreeSummary, err error) {
+	if err != nil {
+		status.LastSyncError = err.Error()
+		return
+	}
+
+	now := metav1.Now()
+	status.LastSyncTime = &now
+	status.LastSyncError = ""
+	if summary == nil {
+		return
+	}
+
+	status.ResourceCount = summary.ResourceCount
+	status.ExcludedCount = summary.ExcludedCount
+	status.UnreachableResources = summary.Unreachable
+	if len(status.UnreachableResources) > maxUnreachableResources {
+		status.UnreachableResources = status.UnreachableResources[:maxUnreachableResources]
+	}
+}
+
+// RecordHealth writes the health rollup of the trees to the status of a CompositionReference
+func RecordHealth(cr *watcher.CompositionReference, rollup *health.Rollup) {
+	score := rollup.Score()
+	cr.Status.Health = string(rollup.Status())
+	cr.Status.HealthScore = &score
+	if rollup.Status() == health.Healthy {
+		cr.SetConditions(watcher.TreeHealthy())
+	} else {
+		cr.SetConditions(watcher.TreeNotHealthy(string(rollup.Status()), rollup.Message()))
+	}
+}
+
+// HealthWeights returns the criticality of the kinds in the health rollup
+func HealthWeights(cr *watcher.CompositionReference) map[schema.GroupKind]int {
+	if cr.Spec.Health == nil {
+		return nil
+	}
+	weights := make(map[schema.GroupKind]int, len(cr.Spec.Health.Weights))
+	for _, w := range cr.Spec.Health.Weights {
+		weights[schema.GroupKind{Group: w.Group, Kind: w.Kind}] = w.Weight
+	}
+	return weights
+}
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "composition_watcher"
+
+var (
+	// TreeUpdatesDropped counts the tree updates given up after exhausting their retries
+	TreeUpdatesDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tree_updates_dropped_total",
+		Help:      "Number of informer driven tree updates dropped after exhausting their retries.",
+	})
+
+	// TreeUpdateRetries counts the tree updates requeued after a failure
+	TreeUpdateRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tree_update_retries_total",
+		Help:      "Number of informer driven tree updates retried after a failure.",
+	})
+
+	// ActiveInformers is the number of running shared informers
+	ActiveInformers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "informers_active",
+		Help:      "Number of running shared informers.",
+	})
+
+	// InformerEvents counts the events received by the shared informers, by type (add, update, delete)
+	InformerEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "informer_events_total",
+		Help:      "Number of events received by the shared informers, by type.",
+	}, []string{"type"})
+
+	// TreeBuildDuration measures how long building the tree of a composition takes
+	TreeBuildDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tree_build_duration_seconds",
+		Help:      "Time taken to build the resource tree of a composition.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+
+	// ManagedResourcesFetched counts the managed resources fetched to build the trees, per GVR
+	ManagedResourcesFetched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "managed_resources_fetched_total",
+		Help:      "Number of managed resources fetched to build the resource trees.",
+	}, []string{"group", "version", "resource"})
+
+	// ManagedResourcesFailed counts the managed resources that could not be fetched, per GVR.
+	// Resources that do not exist are not failures, they are in the tree as Missing.
+	ManagedResourcesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "managed_resources_failed_total",
+		Help:      "Number of managed resources that could not be fetched to build the resource trees.",
+	}, []string{"group", "version", "resource"})
+
+	// PayloadSize measures the bodies sent to the resource-tree-handler, per method
+	PayloadSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "handler_payload_size_bytes",
+		Help:      "Size of the bodies sent to the resource-tree-handler.",
+		Buckets:   prometheus.ExponentialBuckets(256, 4, 10),
+	}, []string{"method"})
+
+	// HandlerRequestDuration measures the requests to the resource-tree-handler, per method
+	HandlerRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "handler_request_duration_seconds",
+		Help:      "Latency of the requests to the resource-tree-handler.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	// HandlerRequests counts the requests to the resource-tree-handler, per method and status
+	// code. The code is "error" when no response was received.
+	HandlerRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "handler_requests_total",
+		Help:      "Number of requests to the resource-tree-handler, by method and status code.",
+	}, []string{"method", "code"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		TreeUpdatesDropped,
+		TreeUpdateRetries,
+		ActiveInformers,
+		InformerEvents,
+		TreeBuildDuration,
+		ManagedResourcesFetched,
+		ManagedResourcesFailed,
+		PayloadSize,
+		HandlerRequestDuration,
+		HandlerRequests,
+	)
+}
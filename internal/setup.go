@@ -5,15 +5,16 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 
 	watcher "github.com/krateoplatformops/composition-watcher/internal/controller"
+	"github.com/krateoplatformops/composition-watcher/internal/helpers/debug"
 )
 
 // Setup creates all controllers with the supplied logger and adds them to
 // the supplied manager.
-func Setup(mgr ctrl.Manager, o controller.Options) error {
-	for _, setup := range []func(ctrl.Manager, controller.Options) error{
+func Setup(mgr ctrl.Manager, o controller.Options, debugOpts debug.Options) error {
+	for _, setup := range []func(ctrl.Manager, controller.Options, debug.Options) error{
 		watcher.Setup,
 	} {
-		if err := setup(mgr, o); err != nil {
+		if err := setup(mgr, o, debugOpts); err != nil {
 			return err
 		}
 	}
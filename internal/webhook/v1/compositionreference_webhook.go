@@ -0,0 +1,221 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	watcher "github.com/krateoplatformops/composition-watcher/api/v1"
+	"github.com/krateoplatformops/composition-watcher/internal/helpers/filters"
+)
+
+// SetupCompositionReferenceWebhookWithManager registers the defaulting and
+// validating webhooks for CompositionReference on the manager webhook server.
+func SetupCompositionReferenceWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&watcher.CompositionReference{}).
+		WithDefaulter(&CompositionReferenceCustomDefaulter{}).
+		WithValidator(&CompositionReferenceCustomValidator{client: mgr.GetClient()}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-resourcetrees-krateo-io-v1-compositionreference,mutating=true,failurePolicy=fail,sideEffects=None,groups=resourcetrees.krateo.io,resources=compositionreferences,verbs=create;update,versions=v1,name=mcompositionreference.krateo.io,admissionReviewVersions=v1
+
+// CompositionReferenceCustomDefaulter fills in the reference namespace from the
+// namespace of the CompositionReference when it is omitted.
+type CompositionReferenceCustomDefaulter struct{}
+
+var _ webhook.CustomDefaulter = &CompositionReferenceCustomDefaulter{}
+
+func (d *CompositionReferenceCustomDefaulter) Default(_ context.Context, obj runtime.Object) error {
+	cr, ok := obj.(*watcher.CompositionReference)
+	if !ok {
+		return fmt.Errorf("expected a CompositionReference object but got %T", obj)
+	}
+
+	if cr.Spec.Reference != nil && cr.Spec.Reference.Namespace == "" {
+		cr.Spec.Reference.Namespace = cr.Namespace
+	}
+	return nil
+}
+
+// +kubebuilder:webhook:path=/validate-resourcetrees-krateo-io-v1-compositionreference,mutating=false,failurePolicy=fail,sideEffects=None,groups=resourcetrees.krateo.io,resources=compositionreferences,verbs=create;update,versions=v1,name=vcompositionreference.krateo.io,admissionReviewVersions=v1
+
+// CompositionReferenceCustomValidator rejects CompositionReferences that the
+// controller would not be able to reconcile.
+type CompositionReferenceCustomValidator struct {
+	client client.Client
+}
+
+var _ webhook.CustomValidator = &CompositionReferenceCustomValidator{}
+
+func (v *CompositionReferenceCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	cr, ok := obj.(*watcher.CompositionReference)
+	if !ok {
+		return nil, fmt.Errorf("expected a CompositionReference object but got %T", obj)
+	}
+	return nil, v.validate(ctx, cr, true)
+}
+
+// ValidateUpdate only validates spec changes, so that finalizers and metadata can always be
+// updated, e.g. to delete a CompositionReference created before the webhook was enabled.
+// The duplicate check only runs when the reference changes.
+func (v *CompositionReferenceCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	cr, ok := newObj.(*watcher.CompositionReference)
+	if !ok {
+		return nil, fmt.Errorf("expected a CompositionReference object but got %T", newObj)
+	}
+	old, ok := oldObj.(*watcher.CompositionReference)
+	if !ok {
+		return nil, fmt.Errorf("expected a CompositionReference object but got %T", oldObj)
+	}
+
+	if cr.DeletionTimestamp != nil || equality.Semantic.DeepEqual(old.Spec, cr.Spec) {
+		return nil, nil
+	}
+	return nil, v.validate(ctx, cr, !equality.Semantic.DeepEqual(old.Spec.Reference, cr.Spec.Reference))
+}
+
+func (v *CompositionReferenceCustomValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *CompositionReferenceCustomValidator) validate(ctx context.Context, cr *watcher.CompositionReference, checkDuplicate bool) error {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	switch {
+	case cr.Spec.Reference == nil && cr.Spec.Selector == nil:
+		allErrs = append(allErrs, field.Required(specPath, "either reference or selector must be specified"))
+	case cr.Spec.Reference != nil && cr.Spec.Selector != nil:
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("selector"), "reference and selector are mutually exclusive"))
+	}
+
+	if ref := cr.Spec.Reference; ref != nil {
+		refPath := specPath.Child("reference")
+		allErrs = append(allErrs, validateApiVersion(refPath.Child("apiVersion"), ref.ApiVersion)...)
+		if ref.Resource == "" {
+			allErrs = append(allErrs, field.Required(refPath.Child("resource"), ""))
+		}
+		if ref.Name == "" {
+			allErrs = append(allErrs, field.Required(refPath.Child("name"), ""))
+		}
+		if len(allErrs) == 0 && checkDuplicate {
+			duplicate, err := v.findDuplicate(ctx, cr)
+			if err != nil {
+				return err
+			}
+			if duplicate != "" {
+				allErrs = append(allErrs, field.Duplicate(refPath, fmt.Sprintf("composition is already referenced by %s", duplicate)))
+			}
+		}
+	}
+
+	if sel := cr.Spec.Selector; sel != nil {
+		selPath := specPath.Child("selector")
+		allErrs = append(allErrs, validateApiVersion(selPath.Child("apiVersion"), sel.ApiVersion)...)
+		if sel.Resource == "" {
+			allErrs = append(allErrs, field.Required(selPath.Child("resource"), ""))
+		}
+	}
+
+	if children := cr.Spec.Children; children != nil {
+		for i, childResource := range children.Resources {
+			childPath := specPath.Child("children", "resources").Index(i)
+			allErrs = append(allErrs, validateApiVersion(childPath.Child("apiVersion"), childResource.ApiVersion)...)
+			if childResource.Resource == "" {
+				allErrs = append(allErrs, field.Required(childPath.Child("resource"), ""))
+			}
+		}
+	}
+
+	for i, path := range cr.Spec.WatchedPaths {
+		if slices.Contains(strings.Split(path, "."), "") {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("watchedPaths").Index(i), path, "must be a dot separated path without empty fields"))
+		}
+	}
+
+	for i, rule := range cr.Spec.Filters.Include {
+		if err := filters.ValidateRule(rule); err != nil {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("filters", "include").Index(i), rule, err.Error()))
+		}
+	}
+	for i, rule := range cr.Spec.Filters.Exclude {
+		if err := filters.ValidateRule(rule); err != nil {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("filters", "exclude").Index(i), rule, err.Error()))
+		}
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(watcher.CompositionReferenceGroupVersionKind.GroupKind(), cr.Name, allErrs)
+}
+
+func validateApiVersion(path *field.Path, apiVersion string) field.ErrorList {
+	if apiVersion == "" {
+		return field.ErrorList{field.Required(path, "")}
+	}
+	if _, err := schema.ParseGroupVersion(apiVersion); err != nil {
+		return field.ErrorList{field.Invalid(path, apiVersion, err.Error())}
+	}
+	return nil
+}
+
+// findDuplicate returns the namespaced name of another CompositionReference
+// pointing at the same composition, if any. The references are compared once
+// defaulted, since those created before the webhook may omit the namespace.
+func (v *CompositionReferenceCustomValidator) findDuplicate(ctx context.Context, cr *watcher.CompositionReference) (string, error) {
+	list := &watcher.CompositionReferenceList{}
+	if err := v.client.List(ctx, list); err != nil {
+		return "", fmt.Errorf("unable to list compositionreferences: %w", err)
+	}
+
+	reference := defaultedReference(cr)
+	for i := range list.Items {
+		other := &list.Items[i]
+		if other.Namespace == cr.Namespace && other.Name == cr.Name {
+			continue
+		}
+		if other.Spec.Reference != nil && defaultedReference(other) == reference {
+			return fmt.Sprintf("%s/%s", other.Namespace, other.Name), nil
+		}
+	}
+	return "", nil
+}
+
+// defaultedReference returns the reference of a CompositionReference as the defaulting webhook sets it
+func defaultedReference(cr *watcher.CompositionReference) watcher.Reference {
+	reference := *cr.Spec.Reference
+	if reference.Namespace == "" {
+		reference.Namespace = cr.Namespace
+	}
+	return reference
+}